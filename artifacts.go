@@ -0,0 +1,112 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed artifacts.html
+var artifactsTemplate string
+
+var artifactsTpl = template.Must(template.New("artifacts.html").Parse(artifactsTemplate))
+
+// An artifactEntry is a file or directory on the artifact browser page.
+type artifactEntry struct {
+	Name string
+	Link string
+	Dir  bool
+	Size string
+}
+
+// artifactsHandler serves /artifacts/{buildID}/{dir}/, a page listing the
+// artifacts in one directory of a build, with links to browse into
+// subdirectories. Each directory is fetched from TeamCity when viewed. Only
+// builds on the page can be browsed.
+func artifactsHandler(w http.ResponseWriter, req *http.Request) {
+	rest := strings.TrimPrefix(req.URL.Path, "/artifacts/")
+	idStr, dir, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idStr)
+	srv, ok := serverParam(req)
+	if err != nil || !ok || !listedBuild(srv, id) {
+		http.NotFound(w, req)
+		return
+	}
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		// Keep relative links working
+		u := *req.URL
+		u.Path += "/"
+		http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+		return
+	}
+	dir = strings.Trim(dir, "/")
+	for _, seg := range strings.Split(dir, "/") {
+		if seg == ".." || seg == "." {
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	files, err := getDir(req.Context(), srv, id, dir)
+	if err != nil {
+		errorln(err)
+		http.Error(w, "Could not list artifacts", http.StatusBadGateway)
+		return
+	}
+
+	// Links are relative to the directory, so that we can be served under
+	// any prefix.
+	root := strings.Repeat("../", strings.Count(req.URL.Path, "/")-1)
+	q := serverQuery(srv)
+
+	var entries []artifactEntry
+	for _, f := range filterFiles(files) {
+		e := artifactEntry{Name: path.Base(f.Name)}
+		if f.Content.HRef == "" {
+			e.Dir = true
+			e.Link = "./" + escapeSegment(e.Name) + "/" + q
+		} else {
+			e.Size = f.SizeStr()
+			e.Link = downloadURL(srv, id, f)
+			if !strings.Contains(e.Link, "://") {
+				e.Link = root + e.Link
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	type crumb struct{ Name, Link string }
+	crumbs := []crumb{{Name: idStr, Link: root + "artifacts/" + idStr + "/" + q}}
+	if dir != "" {
+		segs := strings.Split(dir, "/")
+		for i, seg := range segs {
+			up := strings.Repeat("../", len(segs)-1-i)
+			if up == "" {
+				up = "./"
+			}
+			crumbs = append(crumbs, crumb{Name: seg, Link: up + q})
+		}
+	}
+
+	data := map[string]interface{}{
+		"BuildID": id,
+		"Root":    root,
+		"Crumbs":  crumbs,
+		"Entries": entries,
+		"CDN":     useCDN,
+		"Theme":   theme,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := artifactsTpl.Execute(w, data); err != nil {
+		errorln("Artifacts page:", err)
+	}
+}
+
+// escapeSegment escapes a file name for use in a relative link.
+func escapeSegment(s string) string {
+	return (&url.URL{Path: s}).EscapedPath()
+}
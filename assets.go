@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// assets holds the static files served under /assets/.
+//
+//go:embed assets
+var assets embed.FS
+
+// assetsHandler serves the embedded assets. They only change with the
+// binary, so clients may cache them for a while.
+func assetsHandler() http.Handler {
+	fs := http.FileServer(http.FS(assets))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		fs.ServeHTTP(w, req)
+	})
+}
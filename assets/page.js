@@ -0,0 +1,63 @@
+// Filter projects and build types by name as the user types.
+// The filter box stays hidden without JavaScript.
+(function () {
+  var input = document.getElementById("filter");
+  input.hidden = false;
+  input.addEventListener("input", function () {
+    var q = input.value.trim().toLowerCase();
+    document.querySelectorAll(".project-section").forEach(function (p) {
+      var projMatch = p.getAttribute("data-project").toLowerCase().indexOf(q) >= 0;
+      var any = false;
+      p.querySelectorAll(".build").forEach(function (b) {
+        var show = projMatch || b.getAttribute("data-name").toLowerCase().indexOf(q) >= 0;
+        b.hidden = !show;
+        any = any || show;
+      });
+      p.hidden = q !== "" && !any;
+    });
+  });
+})();
+
+// Remember which projects were opened or closed, per browser.
+(function () {
+  var cookie = "tcbuilds-projects=";
+  var state = {};
+  document.cookie.split("; ").forEach(function (c) {
+    if (c.indexOf(cookie) === 0) {
+      try {
+        state = JSON.parse(decodeURIComponent(c.substring(cookie.length)));
+      } catch (e) {}
+    }
+  });
+  document.querySelectorAll("details.project").forEach(function (d) {
+    var name = d.getAttribute("data-project");
+    if (name in state) {
+      d.open = state[name];
+    }
+    d.addEventListener("toggle", function () {
+      state[name] = d.open;
+      document.cookie = cookie + encodeURIComponent(JSON.stringify(state)) + "; path=/; max-age=31536000; SameSite=Lax";
+    });
+  });
+})();
+
+// Dismiss the load error banner until the errors change, for the session.
+// The dismiss button stays hidden without JavaScript.
+(function () {
+  var banner = document.getElementById("load-errors");
+  if (!banner) {
+    return;
+  }
+  var key = "tcbuilds-dismissed-errors";
+  var errors = banner.textContent.trim();
+  if (sessionStorage.getItem(key) === errors) {
+    banner.hidden = true;
+    return;
+  }
+  var button = banner.querySelector("button.close");
+  button.hidden = false;
+  button.addEventListener("click", function () {
+    banner.hidden = true;
+    sessionStorage.setItem(key, errors);
+  });
+})();
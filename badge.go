@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+const badgeSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20">
+<linearGradient id="b" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<mask id="a"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></mask>
+<g mask="url(#a)"><path fill="#555" d="M0 0h%[2]dv20H0z"/><path fill="%[5]s" d="M%[2]d 0h%[3]dv20H%[2]dz"/><path fill="url(#b)" d="M0 0h%[1]dv20H0z"/></g>
+<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="11">
+<text x="%[6]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[6]d" y="14">%[4]s</text>
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[8]s</text><text x="%[7]d" y="14">%[8]s</text>
+</g>
+</svg>
+`
+
+// badgeHandler returns an SVG status badge for the latest build of the
+// build type given in the path, regardless of -status, -pinned-only and
+// -tag. Build types not on the page are "unknown".
+func badgeHandler(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/badge/")
+	srv, ok := serverParam(req)
+	if id == "" || !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	b, ok := cachedBuild(srv, id)
+	if listedBuildType(srv, id) && (!ok || server(srv).filtersBuilds()) {
+		// The cached build is the latest matching the filters, not
+		// necessarily the latest one
+		var err error
+		b, err = getLatestAnyBuild(req.Context(), srv, id, branches[0])
+		ok = err == nil
+	}
+
+	msg, color := "unknown", "#9f9f9f"
+	switch {
+	case !ok:
+	case b.State != "finished":
+		msg, color = "running", "#dfb317"
+	case b.Status == "SUCCESS":
+		msg, color = "passing", "#4c1"
+	default:
+		msg, color = "failing", "#e05d44"
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "max-age=60")
+	writeBadge(w, "build", msg, color)
+}
+
+func writeBadge(w http.ResponseWriter, label, msg, color string) {
+	// Approximate text widths; good enough for short ASCII strings.
+	lw := 7*len(label) + 10
+	mw := 7*len(msg) + 10
+	fmt.Fprintf(w, badgeSVG, lw+mw, lw, mw, html.EscapeString(label), color, lw/2, lw+mw/2, html.EscapeString(msg))
+}
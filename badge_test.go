@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBadge(t *testing.T) {
+	var fetched []string
+	fx := fixtures{
+		"/guestAuth/app/rest/builds/id:11": `{"id": 11, "number": "10", "state": "finished", "status": "SUCCESS"}`,
+		"/guestAuth/app/rest/builds/id:12": `{"id": 12, "number": "11", "state": "finished", "status": "FAILURE"}`,
+	}
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetched = append(fetched, req.URL.Path)
+		if strings.HasSuffix(req.URL.Path, "/builds") {
+			// The latest build failed; the latest successful one is older
+			latest := "12"
+			if strings.Contains(req.URL.Query().Get("locator"), "status:SUCCESS") {
+				latest = "11"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"count": 1, "build": [{"id": ` + latest + `, "href": "/guestAuth/app/rest/builds/id:` + latest + `"}]}`))
+			return
+		}
+		fx.ServeHTTP(w, req)
+	}))
+	tc.status = "SUCCESS"
+	bt := buildType{ID: "A_Build", Build: build{ID: 11, State: "finished", Status: "SUCCESS"}}
+	cache.Store(&cacheEntry{
+		projects: []project{{Builds: []buildType{bt}}},
+		results:  []buildResult{{bt: bt, branch: "master"}},
+	})
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/badge/A_Build", "failing"},
+		{"/badge/B_Secret", "unknown"},
+	}
+	for _, c := range cases {
+		fetched = nil
+		rec := httptest.NewRecorder()
+		badgeHandler(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if !strings.Contains(rec.Body.String(), ">"+c.want+"<") {
+			t.Errorf("%s: expected %q in\n%s", c.path, c.want, rec.Body.String())
+		}
+		for _, p := range fetched {
+			if strings.Contains(p, "B_Secret") {
+				t.Errorf("fetched %s from TeamCity for an unlisted build type", p)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// linkRe matches the URLs turned into links in the -footer text.
+var linkRe = regexp.MustCompile(`https?://[^\s<>"']+[^\s<>"'.,;:!?)]`)
+
+// footerHTML returns the -footer text as HTML. The text is escaped, with
+// only the http and https URLs in it made into links, so it can't inject
+// markup.
+func footerHTML(text string) template.HTML {
+	var sb strings.Builder
+	last := 0
+	for _, m := range linkRe.FindAllStringIndex(text, -1) {
+		sb.WriteString(html.EscapeString(text[last:m[0]]))
+		u := html.EscapeString(text[m[0]:m[1]])
+		sb.WriteString(`<a href="` + u + `">` + u + `</a>`)
+		last = m[1]
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+	return template.HTML(sb.String())
+}
+
+// isRemoteLogo returns true if -logo is a URL rather than a local file.
+func isRemoteLogo(logo string) bool {
+	return strings.HasPrefix(logo, "http://") || strings.HasPrefix(logo, "https://")
+}
+
+// logoURL returns the address the page loads the logo from, if any.
+func logoURL() string {
+	switch {
+	case logo == "":
+		return ""
+	case isRemoteLogo(logo):
+		return logo
+	default:
+		return "logo"
+	}
+}
+
+// logoHandler serves the -logo file.
+func logoHandler(w http.ResponseWriter, req *http.Request) {
+	http.ServeFile(w, req, logo)
+}
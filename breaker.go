@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errBreakerOpen is returned instead of making requests to a server that
+// has failed repeatedly, until the cooldown has passed.
+var errBreakerOpen = errors.New("TeamCity is unavailable; not trying again until the cooldown has passed")
+
+// A breaker stops requests to a server after a number of consecutive
+// failures, for a cooldown period. After that requests are let through
+// again; the first success closes the breaker and another failure opens
+// it for another cooldown. A nil breaker lets everything through.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mut       sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns errBreakerOpen if requests should not be made.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return errBreakerOpen
+	}
+	return nil
+}
+
+// record counts the outcome of a request.
+func (b *breaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if ok {
+		if b.failures >= b.threshold {
+			infoln("TeamCity is reachable again")
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.openUntil.IsZero() || !time.Now().Before(b.openUntil) {
+			warnln("TeamCity failed", b.failures, "times in a row; pausing requests for", b.cooldown)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// breakerState is the state of a breaker as reported by /api/status.
+type breakerState struct {
+	Server    string
+	State     string // "closed", "open" or "half-open"
+	Failures  int
+	OpenUntil *time.Time `json:",omitempty"`
+}
+
+func (b *breaker) state() breakerState {
+	if b == nil {
+		return breakerState{State: "closed"}
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	s := breakerState{State: "closed", Failures: b.failures}
+	switch {
+	case time.Now().Before(b.openUntil):
+		s.State = "open"
+		t := b.openUntil
+		s.OpenUntil = &t
+	case b.failures >= b.threshold:
+		s.State = "half-open"
+	}
+	return s
+}
+
+// isBreakerOpen returns true if err was caused by an open breaker.
+func isBreakerOpen(err error) bool {
+	return errors.Cause(err) == errBreakerOpen
+}
+
+// anyBreakerOpen returns true if requests to any server are paused.
+func anyBreakerOpen() bool {
+	for _, c := range servers {
+		if c.breaker.allow() != nil {
+			return true
+		}
+	}
+	return false
+}
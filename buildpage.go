@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// buildPageTTL is how long a rendered build page is served before it is
+// fetched again.
+const buildPageTTL = time.Minute
+
+// maxBuildPageChanges is the number of changes listed on a build page.
+const maxBuildPageChanges = 25
+
+//go:embed build.html
+var buildTemplate string
+
+var buildTpl = template.Must(template.New("build.html").Funcs(templateFuncs).Parse(buildTemplate))
+
+type buildPage struct {
+	data    []byte
+	expires time.Time
+}
+
+var (
+	// Rendered build pages keyed by server and build ID
+	buildPages   = make(map[[2]int]buildPage)
+	buildPageMut sync.Mutex
+)
+
+// buildHandler serves /build/{buildID}, a page with the details, changes
+// and artifacts of a single build on the page.
+func buildHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/build/"))
+	srv, ok := serverParam(req)
+	if err != nil || !ok || !listedBuild(srv, id) {
+		http.NotFound(w, req)
+		return
+	}
+
+	key := [2]int{srv, id}
+	buildPageMut.Lock()
+	page, ok := buildPages[key]
+	buildPageMut.Unlock()
+
+	if !ok || time.Now().After(page.expires) {
+		data, err := renderBuildPage(req.Context(), srv, id)
+		if err != nil {
+			errorln(err)
+			http.Error(w, "Could not load build", http.StatusBadGateway)
+			return
+		}
+		page = buildPage{data: data, expires: time.Now().Add(buildPageTTL)}
+
+		buildPageMut.Lock()
+		for k, p := range buildPages {
+			if time.Now().After(p.expires) {
+				delete(buildPages, k)
+			}
+		}
+		buildPages[key] = page
+		buildPageMut.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.data)
+}
+
+// renderBuildPage fetches the build with its changes and artifacts, with
+// their checksums if -checksums is set, and renders the build page.
+func renderBuildPage(ctx context.Context, srv, id int) ([]byte, error) {
+	b, err := getBuild(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Changes, err = getChanges(ctx, srv, id, maxBuildPageChanges); err != nil {
+		warnln(err)
+	}
+
+	files, err := getFiles(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+	b.Files = filterFiles(files)
+	for i, f := range b.Files {
+		if !checksums || f.Content.HRef == "" {
+			continue
+		}
+		if b.Files[i].SHA256, err = getChecksum(ctx, srv, id, f); err != nil {
+			warnln(err)
+		}
+	}
+	b.TotalSize = totalSize(b.Files)
+
+	// Our own download links are relative to the main page
+	links := make([]string, len(b.Files))
+	for i, f := range b.Files {
+		links[i] = downloadURL(srv, id, f)
+		if !strings.Contains(links[i], "://") {
+			links[i] = "../" + links[i]
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err = buildTpl.Execute(buf, map[string]interface{}{
+		"Build": b,
+		"Links": links,
+		"CDN":   useCDN,
+		"Theme": theme,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "execute build template")
+	}
+	return buf.Bytes(), nil
+}
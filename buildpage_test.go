@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildPageChecksums(t *testing.T) {
+	downloads := 0
+	fx := fixtures{
+		"/guestAuth/app/rest/builds/id:11": `{"id": 11, "number": "10", "state": "finished", "status": "SUCCESS"}`,
+		"/guestAuth/app/rest/changes":      `{"count": 0}`,
+		"/guestAuth/app/rest/builds/id:11/artifacts/children": `{"count": 1, "file": [
+			{"name": "app.zip", "size": 7, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/app.zip"}}
+		]}`,
+	}
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/artifacts/content/") {
+			downloads++
+			w.Write([]byte("content"))
+			return
+		}
+		fx.ServeHTTP(w, req)
+	}))
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{{Build: build{ID: 11}}}}}})
+	t.Cleanup(func() {
+		checksums = false
+		buildPages = make(map[[2]int]buildPage)
+	})
+
+	for _, enabled := range []bool{false, true} {
+		checksums = enabled
+		buildPages = make(map[[2]int]buildPage)
+		downloads = 0
+
+		rec := httptest.NewRecorder()
+		buildHandler(rec, httptest.NewRequest(http.MethodGet, "/build/11", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d", rec.Code)
+		}
+		shown := strings.Contains(rec.Body.String(), "SHA-256")
+		if enabled != shown || enabled != (downloads == 1) {
+			t.Errorf("with -checksums=%v: %d downloads, checksum shown %v", enabled, downloads, shown)
+		}
+	}
+}
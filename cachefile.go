@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cacheFileData is what -cache-file holds: the rendered page and JSON from
+// the last successful refresh.
+type cacheFileData struct {
+	Updated time.Time
+	Page    []byte
+	JSON    json.RawMessage
+}
+
+// saveCacheFile writes the cache entry to path, replacing the file
+// atomically so a crash never leaves a partial file behind.
+func saveCacheFile(path string, c *cacheEntry) error {
+	bs, err := json.Marshal(cacheFileData{
+		Updated: c.updated,
+		Page:    c.data,
+		JSON:    c.json,
+	})
+	if err != nil {
+		return errors.Wrap(err, "save cache file")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "save cache file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "save cache file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "save cache file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "save cache file")
+}
+
+// loadCacheFile returns the cache entry saved in path. It has no build
+// results, so the next refresh is a full one.
+func loadCacheFile(path string) (*cacheEntry, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "load cache file")
+	}
+	var cf cacheFileData
+	if err := json.Unmarshal(bs, &cf); err != nil {
+		return nil, errors.Wrap(err, "load cache file")
+	}
+	var data struct {
+		Projects []project
+	}
+	if err := json.Unmarshal(cf.JSON, &data); err != nil {
+		return nil, errors.Wrap(err, "load cache file")
+	}
+
+	c := &cacheEntry{
+		data:     cf.Page,
+		dataETag: etagFor(cf.Page),
+		json:     cf.JSON,
+		jsonETag: etagFor(cf.JSON),
+		projects: data.Projects,
+		updated:  cf.Updated,
+	}
+	// Compression failures only mean serving uncompressed
+	c.dataGz, _ = gzipBytes(c.data)
+	c.jsonGz, _ = gzipBytes(c.json)
+	return c, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+var (
+	// Changes keyed by server and build ID, as they are fixed once a
+	// build has started.
+	changesCache = make(map[[2]int][]change)
+	changesMut   sync.Mutex
+)
+
+// buildChanges returns up to -changes of the most recent changes in the
+// build, and whether there are more than that.
+func buildChanges(ctx context.Context, srv, buildID int) ([]change, bool, error) {
+	key := [2]int{srv, buildID}
+	changesMut.Lock()
+	cs, ok := changesCache[key]
+	changesMut.Unlock()
+
+	if !ok {
+		// Ask for one extra to know if there are more
+		var err error
+		cs, err = getChanges(ctx, srv, buildID, maxChanges+1)
+		if err != nil {
+			return nil, false, err
+		}
+		changesMut.Lock()
+		changesCache[key] = cs
+		changesMut.Unlock()
+	}
+
+	if len(cs) > maxChanges {
+		return cs[:maxChanges], true, nil
+	}
+	return cs, false, nil
+}
+
+type changeResponse struct {
+	Count   int
+	Changes []change `json:"change"`
+}
+
+type change struct {
+	ID       int
+	Version  string
+	Username string
+	Comment  string
+	WebURL   string
+}
+
+// ShortVersion returns the revision abbreviated for display.
+func (c change) ShortVersion() string {
+	if len(c.Version) > 7 {
+		return c.Version[:7]
+	}
+	return c.Version
+}
+
+// Summary returns the first line of the commit message.
+func (c change) Summary() string {
+	s := strings.TrimSpace(c.Comment)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxChecksums bounds the number of checksums kept in memory.
+const maxChecksums = 4096
+
+var (
+	// Checksums keyed by server, build ID and file name, as artifacts
+	// never change once a build has finished.
+	checksumCache = newLRU[string, string](maxChecksums)
+	checksumMut   sync.Mutex
+)
+
+// getChecksum returns the hex encoded SHA-256 of the file's contents,
+// downloading it unless it has already been computed.
+func getChecksum(ctx context.Context, srv, buildID int, f file) (string, error) {
+	key := fmt.Sprintf("%d/%d/%s", srv, buildID, f.Name)
+
+	checksumMut.Lock()
+	sum, ok := checksumCache.get(key)
+	checksumMut.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	resp, err := getContent(ctx, srv, f.Content.HRef)
+	if err != nil {
+		return "", errors.Wrap(err, "get checksum")
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", errors.Wrap(err, "get checksum")
+	}
+	sum = fmt.Sprintf("%x", h.Sum(nil))
+
+	checksumMut.Lock()
+	checksumCache.put(key, sum)
+	checksumMut.Unlock()
+
+	return sum, nil
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Fields requested from TeamCity, limited to what we unmarshal and use.
+const (
+	buildTypeFields = "count,href,nextHref,buildType(id,name,projectName,projectId,href,webUrl)"
+	buildListFields = "count,href,nextHref,build(id,href,number,state,status,statusText,webUrl,finishDate)"
+	buildFields     = "id,buildTypeId,number,state,status,branchName,defaultBranch,href,webUrl,statusText,percentageComplete,pinned,tags(tag(name)),queuedDate,startDate,finishDate,agent(name),revisions(revision(version)),triggered(type,details,user(username,name),buildType(name))"
+	changeFields    = "count,change(id,version,username,comment,webUrl)"
+	fileFields      = "count,file(name,size,modificationTime,href,content(href),children(href))"
+)
+
+// Artifact directories nested deeper than this are not listed.
+const maxArtifactDepth = 8
+
+// errNoBuild is returned by LatestBuild when there is no matching build.
+var errNoBuild = errors.New("no build found")
+
+// An authError is the cause of errors from requests that TeamCity rejected
+// as unauthorized. Such requests are not retried.
+type authError struct {
+	status string
+	flag   string // the credentials in use, -auth or -token, if any
+}
+
+func (e authError) Error() string {
+	if e.flag == "" {
+		return e.status + ": TeamCity requires credentials; set -auth or -token"
+	}
+	return e.status + ": TeamCity rejected the credentials; check " + e.flag
+}
+
+// A Client talks to a TeamCity server.
+type Client struct {
+	base     string
+	auth     string // username:password
+	token    string
+	agent    string // User-Agent header
+	authMode string // "guest", "http", or "auto" to follow the credentials
+	project  string // top level project ID, if any
+	status   string // build status to look for, or "ANY"
+	running  bool   // whether to consider running builds
+	pinned   bool   // whether to consider only pinned builds
+	tag      string // tag to look for, if any
+	history  int    // number of builds to get per build type, at least one
+
+	retries    int
+	retryDelay time.Duration
+	httpClient *http.Client
+	breaker    *breaker // shared by copies of the client
+}
+
+// server returns the client for the given index into servers, or the
+// default client if the index is out of range.
+func server(srv int) *Client {
+	if srv < 0 || srv >= len(servers) {
+		return tc
+	}
+	return servers[srv]
+}
+
+// The free functions below operate on the clients configured from the
+// command line, by index into servers, and tag the results with it.
+
+func getBuildTypes(ctx context.Context, srv int) ([]buildType, error) {
+	types, err := server(srv).BuildTypes(ctx)
+	for i := range types {
+		types[i].Server = srv
+	}
+	return types, err
+}
+
+func getLatestBuild(ctx context.Context, srv int, buildTypeID, branch string) (build, error) {
+	b, err := server(srv).LatestBuild(ctx, buildTypeID, branch)
+	b.Server = srv
+	return b, err
+}
+
+// getLatestAnyBuild is like getLatestBuild, but ignores -status,
+// -pinned-only and -tag.
+func getLatestAnyBuild(ctx context.Context, srv int, buildTypeID, branch string) (build, error) {
+	c := *server(srv)
+	c.status = "ANY"
+	c.pinned = false
+	c.tag = ""
+	c.history = 1
+	b, err := c.LatestBuild(ctx, buildTypeID, branch)
+	b.Server = srv
+	return b, err
+}
+
+func getBuild(ctx context.Context, srv, buildID int) (build, error) {
+	b, err := server(srv).Build(ctx, buildID)
+	b.Server = srv
+	return b, err
+}
+
+func getFiles(ctx context.Context, srv, buildID int) ([]file, error) {
+	return server(srv).Files(ctx, buildID)
+}
+
+func getDir(ctx context.Context, srv, buildID int, dir string) ([]file, error) {
+	return server(srv).Dir(ctx, buildID, dir)
+}
+
+func getChanges(ctx context.Context, srv, buildID, count int) ([]change, error) {
+	return server(srv).Changes(ctx, buildID, count)
+}
+
+func getStatistics(ctx context.Context, srv, buildID int) (map[string]string, error) {
+	return server(srv).Statistics(ctx, buildID)
+}
+
+func getContent(ctx context.Context, srv int, url string) (*http.Response, error) {
+	return server(srv).Content(ctx, url)
+}
+
+// Name returns the host name of the server, for display.
+func (c *Client) Name() string {
+	if u, err := url.Parse(c.base); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return c.base
+}
+
+func (c *Client) BuildTypes(ctx context.Context) ([]buildType, error) {
+	url := "/app/rest/buildTypes?fields=" + buildTypeFields
+	if c.project != "" {
+		url += "&locator=affectedProject:(id:" + c.project + ")"
+	}
+
+	// Follow the pagination links until we have all build types
+	var types []buildType
+	for url != "" {
+		var res buildTypeResponse
+		if err := c.getJSON(ctx, url, &res); err != nil {
+			return nil, errors.Wrap(err, "get build types")
+		}
+		types = append(types, res.BuildTypes...)
+		url = res.NextHRef
+	}
+	return types, nil
+}
+
+// LatestBuild returns the latest build of the build type on the branch,
+// with up to history-1 earlier builds in its History.
+func (c *Client) LatestBuild(ctx context.Context, buildTypeID, branch string) (build, error) {
+	url := fmt.Sprintf("/app/rest/buildTypes/id:%s/builds?locator=%s&fields=%s", buildTypeID, c.buildLocator(branch), buildListFields)
+	var res buildResponse
+	if err := c.getJSON(ctx, url, &res); err != nil {
+		return build{}, errors.Wrap(err, "get latest build")
+	}
+	if len(res.Builds) == 0 {
+		return build{}, errNoBuild
+	}
+
+	// re-get the build for more info
+
+	var b build
+	if err := c.getJSON(ctx, res.Builds[0].HRef+"?fields="+buildFields, &b); err != nil {
+		return build{}, errors.Wrap(err, "get latest build details")
+	}
+	b.History = res.Builds[1:]
+
+	return b, nil
+}
+
+// Build returns the build with the given ID.
+func (c *Client) Build(ctx context.Context, buildID int) (build, error) {
+	url := fmt.Sprintf("/app/rest/builds/id:%d?fields=%s", buildID, buildFields)
+	var b build
+	if err := c.getJSON(ctx, url, &b); err != nil {
+		return build{}, errors.Wrap(err, "get build")
+	}
+	return b, nil
+}
+
+// buildLocator returns the locator selecting the latest builds on the
+// branch, according to the configured status, pinning, tag, running builds
+// and history.
+func (c *Client) buildLocator(branch string) string {
+	loc := "branch:" + branchLocator(branch)
+	if c.running {
+		loc += ",running:any"
+	} else {
+		loc += ",state:finished"
+	}
+	if c.status != "" && c.status != "ANY" {
+		loc += ",status:" + c.status
+	}
+	if c.pinned {
+		loc += ",pinned:true"
+	}
+	if c.tag != "" {
+		loc += ",tag:" + c.tag
+	}
+	count := c.history
+	if count < 1 {
+		count = 1
+	}
+	return fmt.Sprintf("%s,count:%d", loc, count)
+}
+
+// filtersBuilds returns true if the configured status, pinning or tag
+// excludes some builds from the latest build lookups.
+func (c *Client) filtersBuilds() bool {
+	return c.status != "" && c.status != "ANY" || c.pinned || c.tag != ""
+}
+
+// branchLocator returns the TeamCity branch locator for a -branch entry:
+// "*" for any branch, "default" for the default branch, a locator such as
+// "default:any" or "name:release-1.x" as is, otherwise the branch name.
+func branchLocator(branch string) string {
+	switch {
+	case branch == "*":
+		return "(default:any)"
+	case branch == "default":
+		return "(default:true)"
+	case strings.Contains(branch, ":"):
+		return "(" + branch + ")"
+	}
+	return branch
+}
+
+// checkBranch returns an error for -branch entries that would make a broken
+// locator.
+func checkBranch(branch string) error {
+	depth := 0
+	for _, r := range branch {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unbalanced parentheses in %q", branch)
+			}
+		case unicode.IsSpace(r):
+			return fmt.Errorf("white space in %q", branch)
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced parentheses in %q", branch)
+	}
+	if strings.HasPrefix(branch, ":") || strings.HasSuffix(branch, ":") {
+		return fmt.Errorf("missing locator dimension or value in %q", branch)
+	}
+	return nil
+}
+
+// Files returns the artifacts of a build. Artifacts in directories are
+// included, named by their path within the build.
+func (c *Client) Files(ctx context.Context, buildID int) ([]file, error) {
+	url := fmt.Sprintf("/app/rest/builds/id:%d/artifacts/children", buildID)
+	return c.files(ctx, url, "", 0)
+}
+
+// files lists the artifacts below the given children URL, recursing into
+// directories up to maxArtifactDepth.
+func (c *Client) files(ctx context.Context, url, prefix string, depth int) ([]file, error) {
+	var res artifactResponse
+	if err := c.getJSON(ctx, url+"?fields="+fileFields, &res); err != nil {
+		return nil, errors.Wrap(err, "get files")
+	}
+
+	var files []file
+	for _, f := range res.Files {
+		f.Name = prefix + f.Name
+		if f.Children.HRef == "" || f.Content.HRef != "" {
+			files = append(files, f)
+			continue
+		}
+		if depth >= maxArtifactDepth {
+			// Too deep to list, keep the directory itself
+			files = append(files, f)
+			continue
+		}
+		sub, err := c.files(ctx, f.Children.HRef, f.Name+"/", depth+1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sub...)
+	}
+	return files, nil
+}
+
+// Dir returns the artifacts directly in the given directory of a build,
+// or its root if dir is empty, named by their path within the build.
+func (c *Client) Dir(ctx context.Context, buildID int, dir string) ([]file, error) {
+	escDir := (&url.URL{Path: dir}).EscapedPath()
+	url := fmt.Sprintf("/app/rest/builds/id:%d/artifacts/children/%s?fields=%s", buildID, escDir, fileFields)
+	var res artifactResponse
+	if err := c.getJSON(ctx, url, &res); err != nil {
+		return nil, errors.Wrap(err, "get files")
+	}
+	if dir != "" {
+		for i := range res.Files {
+			res.Files[i].Name = dir + "/" + res.Files[i].Name
+		}
+	}
+	return res.Files, nil
+}
+
+// Changes returns up to count of the most recent VCS changes in a build.
+func (c *Client) Changes(ctx context.Context, buildID, count int) ([]change, error) {
+	url := fmt.Sprintf("/app/rest/changes?locator=build:(id:%d),count:%d&fields=%s", buildID, count, changeFields)
+	var res changeResponse
+	if err := c.getJSON(ctx, url, &res); err != nil {
+		return nil, errors.Wrap(err, "get changes")
+	}
+	return res.Changes, nil
+}
+
+// Statistics returns the statistic values of the build, by name.
+func (c *Client) Statistics(ctx context.Context, buildID int) (map[string]string, error) {
+	url := fmt.Sprintf("/app/rest/builds/id:%d/statistics", buildID)
+	var res statisticsResponse
+	if err := c.getJSON(ctx, url, &res); err != nil {
+		return nil, errors.Wrap(err, "get statistics")
+	}
+	stats := make(map[string]string, len(res.Properties))
+	for _, p := range res.Properties {
+		stats[p.Name] = p.Value
+	}
+	return stats, nil
+}
+
+type statisticsResponse struct {
+	Properties []struct {
+		Name  string
+		Value string
+	} `json:"property"`
+}
+
+// Content performs a GET of the given TeamCity URL, such as an artifact
+// content HRef. The caller must close the response body.
+func (c *Client) Content(ctx context.Context, url string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		metricHTTPErrors.Inc()
+		return nil, c.statusError(resp)
+	}
+
+	return resp, nil
+}
+
+// getJSON fetches the given TeamCity URL and unmarshals the response into
+// the given value. Network errors and 5xx responses are retried with
+// exponential backoff.
+func (c *Client) getJSON(ctx context.Context, url string, into interface{}) error {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := c.getJSONOnce(ctx, url, into)
+		if err == nil || !retry || attempt >= c.retries {
+			return err
+		}
+		warnln(fmt.Sprintf("Retrying in %v:", delay), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// getJSONOnce performs a single request. The returned bool indicates
+// whether the error is transient and the request may be retried.
+func (c *Client) getJSONOnce(ctx context.Context, url string, into interface{}) (bool, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return !isBreakerOpen(err), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		metricHTTPErrors.Inc()
+		return resp.StatusCode >= 500, c.statusError(resp)
+	}
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, errors.Wrap(err, "HTTP read")
+	}
+
+	return false, errors.Wrap(json.Unmarshal(bs, into), "JSON unmarshal")
+}
+
+// Error responses are included in the error message up to this length.
+const maxErrorBody = 512
+
+// statusError returns the error for a non-200 response from TeamCity,
+// naming the credentials to check if they were rejected.
+func (c *Client) statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		flag := ""
+		switch {
+		case c.token != "":
+			flag = "-token"
+		case c.auth != "":
+			flag = "-auth"
+		}
+		return authError{status: resp.Status, flag: flag}
+	}
+	return statusError(resp)
+}
+
+// statusError returns the error for a non-200 response, including the
+// start of the response body which usually says what the server didn't
+// like.
+func statusError(resp *http.Response) error {
+	bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody+1))
+	truncated := len(bs) > maxErrorBody
+	if truncated {
+		bs = bs[:maxErrorBody]
+	}
+	msg := strings.Join(strings.Fields(strings.ToValidUTF8(string(bs), "")), " ")
+	if truncated {
+		msg += "..."
+	}
+	if msg == "" {
+		return errors.New(resp.Status)
+	}
+	return errors.New(resp.Status + ": " + msg)
+}
+
+// isAuthError returns true if err was caused by TeamCity rejecting the
+// credentials.
+func isAuthError(err error) bool {
+	_, ok := errors.Cause(err).(authError)
+	return ok
+}
+
+// newRequest returns a GET request for the given TeamCity URL, with the
+// auth prefix and credentials set according to the configuration.
+func (c *Client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	authPart := ""
+	switch {
+	case strings.HasPrefix(url, "/guestAuth"):
+	case strings.HasPrefix(url, "/httpAuth"):
+	case c.authMode == "http":
+		authPart = "/httpAuth"
+	case c.authMode == "guest":
+		authPart = "/guestAuth"
+	case c.auth != "", c.token != "":
+		authPart = "/httpAuth"
+	default:
+		authPart = "/guestAuth"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+authPart+url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	if c.agent != "" {
+		req.Header.Set("User-Agent", c.agent)
+	}
+
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.auth != "":
+		user, pass, _ := strings.Cut(c.auth, ":")
+		req.SetBasicAuth(user, pass)
+	}
+
+	return req, nil
+}
+
+// newTransport returns an HTTP transport trusting the CA certificates in
+// the given PEM file in addition to the system roots, if set, or skipping
+// verification altogether if insecure is set. Requests go via the given
+// proxy URL, if set, or the one from the environment.
+func newTransport(caCertFile string, insecure bool, proxy string) (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply unless overridden
+	tr.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return nil, errors.New("invalid -proxy URL " + proxy)
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+
+	if caCertFile != "" {
+		bs, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load CA certificates")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, errors.New("load CA certificates: no certificates found in " + caCertFile)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+
+	return tr, nil
+}
+
+// proxyFor returns the proxy the transport uses to reach the given base
+// URL, or nil if connecting directly.
+func proxyFor(tr *http.Transport, base string) *url.URL {
+	req, err := http.NewRequest(http.MethodGet, base, nil)
+	if err != nil {
+		return nil
+	}
+	u, _ := tr.Proxy(req)
+	return u
+}
+
+// do performs the request, logging it and counting failures.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	t0 := time.Now()
+	resp, err := c.httpClient.Do(req)
+	c.breaker.record(err == nil && resp.StatusCode < 500)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	logEvent(slog.LevelDebug, "http_get", req.URL.String(), "url", req.URL.String(), "status", status, "duration_ms", time.Since(t0).Milliseconds(), "error", errString(err))
+
+	if err != nil {
+		metricHTTPErrors.Inc()
+		return nil, errors.Wrap(err, "HTTP get")
+	}
+	return resp, nil
+}
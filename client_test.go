@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fixtures serves canned TeamCity responses by request path.
+type fixtures map[string]string
+
+func (f fixtures) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, ok := f[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(body))
+}
+
+// newTestClient returns a client for a fake TeamCity served by h.
+func newTestClient(t *testing.T, h http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &Client{base: srv.URL, httpClient: srv.Client(), retryDelay: time.Millisecond}
+}
+
+func TestTimeout(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	c.httpClient.Timeout = 50 * time.Millisecond
+
+	t0 := time.Now()
+	_, err := c.BuildTypes(context.Background())
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Errorf("request took %v, expected to time out", d)
+	}
+	if !strings.HasPrefix(err.Error(), "get build types: HTTP get: ") {
+		t.Errorf("error not wrapped: %v", err)
+	}
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestBuildTypes(t *testing.T) {
+	c := newTestClient(t, fixtures{
+		"/guestAuth/app/rest/buildTypes": `{"count": 2, "buildType": [
+			{"id": "A_Build", "name": "Build", "projectName": "Proj A", "projectId": "A", "href": "/guestAuth/app/rest/buildTypes/id:A_Build", "webUrl": "http://tc/bt/A_Build"},
+			{"id": "B_Test", "name": "Test", "projectName": "Proj B", "projectId": "B", "href": "/guestAuth/app/rest/buildTypes/id:B_Test", "webUrl": "http://tc/bt/B_Test"}
+		]}`,
+	})
+
+	types, err := c.BuildTypes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 {
+		t.Fatalf("got %d build types, expected 2", len(types))
+	}
+	bt := types[0]
+	if bt.ID != "A_Build" || bt.Name != "Build" || bt.ProjectName != "Proj A" || bt.ProjectID != "A" || bt.HRef != "/guestAuth/app/rest/buildTypes/id:A_Build" || bt.WebURL != "http://tc/bt/A_Build" {
+		t.Errorf("unexpected build type %+v", bt)
+	}
+	if types[1].ID != "B_Test" || types[1].ProjectName != "Proj B" {
+		t.Errorf("unexpected second build type %+v", types[1])
+	}
+}
+
+func TestLatestBuild(t *testing.T) {
+	var locator string
+	fx := fixtures{
+		"/guestAuth/app/rest/buildTypes/id:A_Build/builds": `{"count": 2, "build": [
+			{"id": 11, "number": "10", "state": "finished", "status": "SUCCESS", "href": "/guestAuth/app/rest/builds/id:11"},
+			{"id": 9, "number": "9", "state": "finished", "status": "SUCCESS", "statusText": "Tests passed: 4", "href": "/guestAuth/app/rest/builds/id:9"}
+		]}`,
+		"/guestAuth/app/rest/builds/id:11": `{"id": 11, "buildTypeId": "A_Build", "number": "10", "state": "finished", "status": "SUCCESS",
+			"branchName": "master", "statusText": "Tests passed: 5", "finishDate": "20260101T101500+0000", "webUrl": "http://tc/build/11",
+			"agent": {"name": "agent-1"}, "pinned": true, "tags": {"tag": [{"name": "release"}]},
+			"revisions": {"revision": [{"version": "0123456789abcdef"}]}}`,
+	}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/builds") {
+			locator = req.URL.Query().Get("locator")
+		}
+		fx.ServeHTTP(w, req)
+	}))
+	c.status = "SUCCESS"
+	c.history = 2
+
+	b, err := c.LatestBuild(context.Background(), "A_Build", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "branch:master,state:finished,status:SUCCESS,count:2"; locator != want {
+		t.Errorf("got locator %q, expected %q", locator, want)
+	}
+	if b.ID != 11 || b.Number != "10" || b.StatusText != "Tests passed: 5" || b.Agent.Name != "agent-1" {
+		t.Errorf("unexpected build %+v", b)
+	}
+	if b.Commit() != "0123456789abcdef" || !b.Pinned || len(b.TagNames()) != 1 || b.TagNames()[0] != "release" {
+		t.Errorf("unexpected build details %+v", b)
+	}
+	if len(b.History) != 1 || b.History[0].ID != 9 || b.History[0].StatusText != "Tests passed: 4" {
+		t.Errorf("unexpected history %+v", b.History)
+	}
+}
+
+func TestLatestBuildNone(t *testing.T) {
+	c := newTestClient(t, fixtures{
+		"/guestAuth/app/rest/buildTypes/id:A_Build/builds": `{"count": 0, "build": []}`,
+	})
+	if _, err := c.LatestBuild(context.Background(), "A_Build", "master"); err != errNoBuild {
+		t.Errorf("got %v, expected errNoBuild", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	c := newTestClient(t, fixtures{
+		"/guestAuth/app/rest/builds/id:11/artifacts/children": `{"count": 2, "file": [
+			{"name": "app-linux-amd64.tar.gz", "size": 1234567, "modificationTime": "20260101T101400+0000", "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/app-linux-amd64.tar.gz"}},
+			{"name": "app-windows-amd64.zip", "size": 2345, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/app-windows-amd64.zip"}}
+		]}`,
+	})
+
+	files, err := c.Files(context.Background(), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, expected 2", len(files))
+	}
+	f := files[0]
+	if f.Name != "app-linux-amd64.tar.gz" || f.Size != 1234567 || f.ModificationTime != "20260101T101400+0000" || f.Content.HRef != "/guestAuth/app/rest/builds/id:11/artifacts/content/app-linux-amd64.tar.gz" {
+		t.Errorf("unexpected file %+v", f)
+	}
+	if files[1].Name != "app-windows-amd64.zip" || files[1].Size != 2345 {
+		t.Errorf("unexpected file %+v", files[1])
+	}
+}
+
+// paged serves the first page for requests without a start in the
+// locator, and the second page otherwise.
+func paged(first, second string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.URL.Query().Get("locator"), "start:2") {
+			w.Write([]byte(second))
+			return
+		}
+		w.Write([]byte(first))
+	})
+}
+
+func TestBuildTypesPaged(t *testing.T) {
+	c := newTestClient(t, paged(
+		`{"count": 2, "nextHref": "/guestAuth/app/rest/buildTypes?locator=start:2,count:2", "buildType": [{"id": "A"}, {"id": "B"}]}`,
+		`{"count": 1, "buildType": [{"id": "C"}]}`,
+	))
+
+	types, err := c.BuildTypes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, bt := range types {
+		ids = append(ids, bt.ID)
+	}
+	if got := strings.Join(ids, ","); got != "A,B,C" {
+		t.Errorf("got build types %s, expected A,B,C", got)
+	}
+}
+
+func TestProjectsPaged(t *testing.T) {
+	c := newTestClient(t, paged(
+		`{"count": 2, "nextHref": "/guestAuth/app/rest/projects?locator=start:2,count:2", "project": [{"id": "_Root"}, {"id": "A", "parentProjectId": "_Root"}]}`,
+		`{"count": 1, "project": [{"id": "A_Sub", "parentProjectId": "A"}]}`,
+	))
+
+	infos, err := c.Projects(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range infos {
+		ids = append(ids, p.ID+"<"+p.ParentProjectID)
+	}
+	if got := strings.Join(ids, ","); got != "_Root<,A<_Root,A_Sub<A" {
+		t.Errorf("got projects %s", got)
+	}
+}
+
+func TestFilesNested(t *testing.T) {
+	c := newTestClient(t, fixtures{
+		"/guestAuth/app/rest/builds/id:11/artifacts/children": `{"count": 2, "file": [
+			{"name": "linux", "children": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/children/linux"}},
+			{"name": "README.txt", "size": 12, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/README.txt"}}
+		]}`,
+		"/guestAuth/app/rest/builds/id:11/artifacts/children/linux": `{"count": 2, "file": [
+			{"name": "amd64", "children": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/children/linux/amd64"}},
+			{"name": "app.deb", "size": 100, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/linux/app.deb"}}
+		]}`,
+		"/guestAuth/app/rest/builds/id:11/artifacts/children/linux/amd64": `{"count": 1, "file": [
+			{"name": "app.tar.gz", "size": 200, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/linux/amd64/app.tar.gz"}}
+		]}`,
+	})
+
+	files, err := c.Files(context.Background(), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, ","), "linux/amd64/app.tar.gz,linux/app.deb,README.txt"; got != want {
+		t.Errorf("got files %s, expected %s", got, want)
+	}
+	if files[0].Size != 200 || files[0].Content.HRef != "/guestAuth/app/rest/builds/id:11/artifacts/content/linux/amd64/app.tar.gz" {
+		t.Errorf("unexpected nested file %+v", files[0])
+	}
+}
+
+func TestFilesDepthLimit(t *testing.T) {
+	// Every directory contains another one, forever
+	requests := 0
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count": 1, "file": [{"name": "d", "children": {"href": "` + req.URL.Path + `/d"}}]}`))
+	}))
+
+	files, err := c.Files(context.Background(), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != maxArtifactDepth+1 {
+		t.Errorf("got %d requests, expected %d", requests, maxArtifactDepth+1)
+	}
+	want := strings.Repeat("d/", maxArtifactDepth) + "d"
+	if len(files) != 1 || files[0].Name != want || files[0].Children.HRef == "" {
+		t.Errorf("got %+v, expected the directory %s", files, want)
+	}
+}
+
+func TestAuthErrorNamesCredentials(t *testing.T) {
+	cases := []struct {
+		auth, token string
+		want        string
+	}{
+		{"", "", "401 Unauthorized: TeamCity requires credentials; set -auth or -token"},
+		{"user:pass", "", "401 Unauthorized: TeamCity rejected the credentials; check -auth"},
+		{"user:pass", "secret", "401 Unauthorized: TeamCity rejected the credentials; check -token"},
+	}
+	for _, cs := range cases {
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		c.auth, c.token = cs.auth, cs.token
+
+		_, err := c.BuildTypes(context.Background())
+		if !isAuthError(err) {
+			t.Fatalf("got %v, expected an auth error", err)
+		}
+		if !strings.HasSuffix(err.Error(), cs.want) {
+			t.Errorf("with -auth %q -token %q: got %q", cs.auth, cs.token, err)
+		}
+	}
+}
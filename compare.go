@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+//go:embed compare.html
+var compareTemplate string
+
+var compareTpl = template.Must(template.New("compare.html").Parse(compareTemplate))
+
+// An artifactDiff is an artifact that differs between two builds.
+type artifactDiff struct {
+	Name   string
+	Change string // "added", "removed" or "changed"
+	SizeA  string // empty if not in the first build
+	SizeB  string // empty if not in the second build
+}
+
+// compareHandler serves /compare?a={buildID}&b={buildID}, a table of the
+// artifacts added, removed or changed in size from build a to build b. Both
+// builds must be on the page.
+func compareHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	idA, errA := strconv.Atoi(q.Get("a"))
+	idB, errB := strconv.Atoi(q.Get("b"))
+	srv, ok := serverParam(req)
+	if errA != nil || errB != nil || !ok {
+		http.Error(w, "Expected build IDs as ?a=...&b=...", http.StatusBadRequest)
+		return
+	}
+	if !listedBuild(srv, idA) || !listedBuild(srv, idB) {
+		http.NotFound(w, req)
+		return
+	}
+
+	a, err := buildWithFiles(req.Context(), srv, idA)
+	if err != nil {
+		errorln("Compare:", err)
+		http.Error(w, "Could not load build", http.StatusBadGateway)
+		return
+	}
+	b, err := buildWithFiles(req.Context(), srv, idB)
+	if err != nil {
+		errorln("Compare:", err)
+		http.Error(w, "Could not load build", http.StatusBadGateway)
+		return
+	}
+
+	diffs, same := diffArtifacts(a.Files, b.Files)
+	buf := new(bytes.Buffer)
+	err = compareTpl.Execute(buf, map[string]interface{}{
+		"A":     a,
+		"B":     b,
+		"Diffs": diffs,
+		"Same":  same,
+		"CDN":   useCDN,
+		"Theme": theme,
+	})
+	if err != nil {
+		errorln("Compare page:", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// buildWithFiles returns the build with its artifacts filled in.
+func buildWithFiles(ctx context.Context, srv, id int) (build, error) {
+	b, err := getBuild(ctx, srv, id)
+	if err != nil {
+		return build{}, err
+	}
+	files, err := getFiles(ctx, srv, id)
+	if err != nil {
+		return build{}, err
+	}
+	b.Files = filterFiles(files)
+	return b, nil
+}
+
+// diffArtifacts returns the differences between the artifacts of two
+// builds, by name, and the number of artifacts that are the same size in
+// both.
+func diffArtifacts(as, bs []file) ([]artifactDiff, int) {
+	sizes := make(map[string]int)
+	for _, f := range as {
+		if f.Content.HRef != "" {
+			sizes[f.Name] = f.Size
+		}
+	}
+
+	var diffs []artifactDiff
+	same := 0
+	for _, f := range bs {
+		if f.Content.HRef == "" {
+			continue
+		}
+		size, ok := sizes[f.Name]
+		delete(sizes, f.Name)
+		switch {
+		case !ok:
+			diffs = append(diffs, artifactDiff{Name: f.Name, Change: "added", SizeB: f.SizeStr()})
+		case size != f.Size:
+			diffs = append(diffs, artifactDiff{Name: f.Name, Change: "changed", SizeA: sizeStr(size), SizeB: f.SizeStr()})
+		default:
+			same++
+		}
+	}
+	for name, size := range sizes {
+		diffs = append(diffs, artifactDiff{Name: name, Change: "removed", SizeA: sizeStr(size)})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return naturalLess(diffs[i].Name, diffs[j].Name)
+	})
+	return diffs, same
+}
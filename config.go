@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper cased flag name, with dashes replaced
+// by underscores, to form the environment variable for a setting.
+const envPrefix = "TCBUILDS_"
+
+// loadEnv applies TCBUILDS_* environment variables to all flags not
+// explicitly given on the command line, and returns the variables used.
+func loadEnv() ([]string, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var used []string
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		env := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		val, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		// Setting it through the flag package marks it as set, so the
+		// config file doesn't override it.
+		if serr := flag.Set(f.Name, val); serr != nil {
+			err = errors.Wrapf(serr, "parse environment: %s", env)
+			return
+		}
+		used = append(used, env)
+	})
+	return used, err
+}
+
+// loadConfig reads a YAML (or JSON) file whose keys are flag names, and
+// applies the values to all flags not explicitly given on the command line.
+// List values are joined with commas.
+func loadConfig(path string) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(bs, &values); err != nil {
+		return errors.Wrap(err, "parse config")
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, val := range values {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("parse config: unknown setting %q", name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, configString(val)); err != nil {
+			return errors.Wrapf(err, "parse config: %s", name)
+		}
+	}
+
+	return nil
+}
+
+func configString(val interface{}) string {
+	switch val := val.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		strs := make([]string, len(val))
+		for i, v := range val {
+			strs[i] = configString(v)
+		}
+		return strings.Join(strs, ",")
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// csvHandler streams the cached builds as CSV, with one row per artifact,
+// or one row per build with ?rows=builds. The page filter parameters
+// apply.
+func csvHandler(w http.ResponseWriter, req *http.Request) {
+	c := currentCache()
+	setCacheAge(w, c)
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	projs := c.projects
+	if f := pageFilterFrom(req); !f.empty() {
+		projs = f.apply(projs)
+	}
+	perBuild := req.URL.Query().Get("rows") == "builds"
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="builds.csv"`)
+	cw := csv.NewWriter(w)
+	header := []string{"project", "build type", "build number", "status", "finish date"}
+	if !perBuild {
+		header = append(header, "file name", "size", "download url")
+	}
+	cw.Write(header)
+
+	for _, p := range projs {
+		for _, bt := range p.Builds {
+			if bt.Pending {
+				continue
+			}
+			b := bt.Build
+			finish := ""
+			if t := b.FinishTime(); !t.IsZero() {
+				finish = t.UTC().Format(time.RFC3339)
+			}
+			row := []string{p.Name, bt.Name, b.Number, b.Status, finish}
+			if perBuild {
+				cw.Write(row)
+				continue
+			}
+			for _, f := range b.Files {
+				if f.Content.HRef == "" {
+					continue
+				}
+				cw.Write(append(row[:5:5], f.Name, strconv.Itoa(f.Size), absURL(req, b.DownloadURL(f))))
+			}
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		errorln("Writing CSV:", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// digestLoop emails a digest of the builds finished since the previous
+// one every -digest-interval, until the context is cancelled. The first
+// digest covers the interval before startup.
+func digestLoop(ctx context.Context) {
+	since := time.Now().Add(-digestInterval)
+	t := time.NewTicker(digestInterval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			if err := sendDigest(since); err != nil {
+				warnln(err)
+				// Try again with the same builds next time
+				continue
+			}
+			since = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDigest emails the builds finished since the given time, unless there
+// are none.
+func sendDigest(since time.Time) error {
+	c := currentCache()
+	if c == nil {
+		return nil
+	}
+
+	var projs []project
+	for _, p := range c.projects {
+		var bts []buildType
+		for _, bt := range p.Builds {
+			if !bt.Pending && bt.Build.FinishTime().After(since) {
+				bts = append(bts, bt)
+			}
+		}
+		if len(bts) > 0 {
+			p.Builds = bts
+			projs = append(projs, p)
+		}
+	}
+	if len(projs) == 0 {
+		debugln("No builds for the digest since", since.Format(time.RFC3339))
+		return nil
+	}
+
+	body := new(bytes.Buffer)
+	writeMarkdown(body, projs, func(u string) string {
+		if strings.Contains(u, "://") || publicURL == "" {
+			return u
+		}
+		return strings.TrimSuffix(publicURL, "/") + "/" + u
+	})
+
+	to := splitList(smtpTo)
+	msg := digestMessage(since, time.Now(), to, body.String())
+
+	var auth smtp.Auth
+	if smtpAuth != "" {
+		user, pass, _ := strings.Cut(smtpAuth, ":")
+		host, _, _ := strings.Cut(smtpServer, ":")
+		auth = smtp.PlainAuth("", user, pass, host)
+	}
+	if err := smtp.SendMail(smtpServer, auth, smtpFrom, to, msg); err != nil {
+		return errors.Wrap(err, "send digest")
+	}
+	infoln("Sent digest to", strings.Join(to, ", "))
+	return nil
+}
+
+// digestMessage returns the email with the given body. The body is
+// Markdown, which reads well enough as plain text and is sent as such,
+// since mail clients don't render text/markdown.
+func digestMessage(since, now time.Time, to []string, body string) []byte {
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", smtpFrom)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: Builds finished since %s\r\n", since.In(displayLoc).Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprint(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(msg, "Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return msg.Bytes()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+func TestDigestMessage(t *testing.T) {
+	since := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	body := "# Proj A\n\n- Build #10: Tests passed: 5 ✓\n"
+	bs := digestMessage(since, since.Add(time.Hour), []string{"a@example.com", "b@example.com"}, body)
+
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	if to := msg.Header.Get("To"); to != "a@example.com, b@example.com" {
+		t.Errorf("got To %q", to)
+	}
+	got := new(bytes.Buffer)
+	got.ReadFrom(msg.Body)
+	if want := "# Proj A\r\n\r\n- Build #10: Tests passed: 5 ✓\r\n"; got.String() != want {
+		t.Errorf("got body %q, expected %q", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Response headers passed on from TeamCity to the client when proxying
+// downloads.
+var proxiedHeaders = []string{
+	"Accept-Ranges",
+	"Content-Disposition",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+}
+
+// downloadHandler proxies /download/{buildID}/{path} to the corresponding
+// TeamCity artifact of a build on the page, using the server side
+// credentials. Hidden artifacts are not served. Range requests are
+// forwarded so downloads can be resumed.
+func downloadHandler(w http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/download/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	id, err := strconv.Atoi(parts[0])
+	srv, ok := serverParam(req)
+	if err != nil || !ok || !listedBuild(srv, id) {
+		http.NotFound(w, req)
+		return
+	}
+	for _, seg := range strings.Split(parts[1], "/") {
+		if seg == ".." {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	// Only the name is known until TeamCity answers; the size is checked
+	// below
+	f := file{Name: parts[1]}
+	if hiddenFile(f) {
+		http.NotFound(w, req)
+		return
+	}
+
+	u := fmt.Sprintf("/app/rest/builds/id:%d/artifacts/content/%s", id, (&url.URL{Path: parts[1]}).EscapedPath())
+	c := server(srv)
+	treq, err := c.newRequest(req.Context(), u)
+	if err != nil {
+		errorln(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if r := req.Header.Get("Range"); r != "" {
+		treq.Header.Set("Range", r)
+	}
+
+	resp, err := c.do(treq)
+	if err != nil {
+		errorln("Proxying download:", err)
+		http.Error(w, "Could not fetch artifact", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusNotFound:
+		http.NotFound(w, req)
+		return
+	default:
+		metricHTTPErrors.Inc()
+		errorln("Proxying download:", resp.Status)
+		http.Error(w, "Could not fetch artifact", http.StatusBadGateway)
+		return
+	}
+	f.Size, f.Content.HRef = int(resp.ContentLength), u
+	if resp.StatusCode == http.StatusOK && hiddenFile(f) {
+		http.NotFound(w, req)
+		return
+	}
+
+	for _, h := range proxiedHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// downloadURL returns the link used for the given artifact: the public or
+// mirrored copy if there is one, the download proxy when credentials are
+// configured for the server, otherwise TeamCity directly.
+func downloadURL(srv, buildID int, f file) string {
+	if f.Public != "" {
+		return f.Public
+	}
+	if f.Mirror != "" {
+		return mirrorURL(f.Mirror)
+	}
+	c := server(srv)
+	if c.auth == "" && c.token == "" {
+		return c.base + f.Content.HRef
+	}
+	return fmt.Sprintf("download/%d/%s%s", buildID, escapePath(f.Name), serverQuery(srv))
+}
+
+// escapePath escapes each segment of a slash separated path for use in a
+// link, so that names containing characters such as # and ? survive.
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
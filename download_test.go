@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProxyOnlyListedBuilds(t *testing.T) {
+	var fetched []string
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetched = append(fetched, req.URL.Path)
+		w.Write([]byte("content"))
+	}))
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{{
+		Build: build{ID: 11, History: []build{{ID: 9}}},
+	}}}}})
+
+	cases := []struct {
+		handler http.HandlerFunc
+		path    string
+		want    int
+	}{
+		{downloadHandler, "/download/11/a.txt", http.StatusOK},
+		{downloadHandler, "/download/9/a.txt", http.StatusOK},
+		{downloadHandler, "/download/12/a.txt", http.StatusNotFound},
+		{zipHandler, "/zip/12", http.StatusNotFound},
+		{artifactsHandler, "/artifacts/12/", http.StatusNotFound},
+		{buildHandler, "/build/12", http.StatusNotFound},
+		{compareHandler, "/compare?a=11&b=12", http.StatusNotFound},
+		{downloadHandler, "/download/11/a.txt?server=1", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		c.handler(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: got status %d, expected %d", c.path, rec.Code, c.want)
+		}
+	}
+
+	for _, p := range fetched {
+		if strings.Contains(p, "id:12") {
+			t.Errorf("fetched %s from TeamCity for an unlisted build", p)
+		}
+	}
+}
+
+func TestProxyHidesFilteredArtifacts(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/empty.txt") {
+			w.Write([]byte("content"))
+		}
+	}))
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{{Build: build{ID: 11}}}}}})
+	oldRe, oldHide := artifactExcludeRe, hideEmpty
+	t.Cleanup(func() { artifactExcludeRe, hideEmpty = oldRe, oldHide })
+	artifactExcludeRe, hideEmpty = regexp.MustCompile(artifactExclude), true
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/download/11/app.zip", http.StatusOK},
+		{"/download/11/.teamcity/settings.xml", http.StatusNotFound},
+		{"/download/11/empty.txt", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		downloadHandler(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: got status %d, expected %d", c.path, rec.Code, c.want)
+		}
+	}
+}
+
+func TestDownloadURLEscapes(t *testing.T) {
+	useTestServer(t, http.NotFoundHandler())
+	tc.auth = "user:pass"
+
+	f := file{Name: "dir #1/app?v=2 100%.zip"}
+	want := "download/11/dir%20%231/app%3Fv=2%20100%25.zip"
+	if got := downloadURL(0, 11, f); got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
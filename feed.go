@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// feedHandler serves an Atom feed with one entry per build type's latest
+// build, newest first.
+func feedHandler(w http.ResponseWriter, req *http.Request) {
+	c := currentCache()
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	var bts []buildType
+	for _, p := range c.projects {
+		for _, bt := range p.Builds {
+			if bt.Build.FinishDate != "" {
+				bts = append(bts, bt)
+			}
+		}
+	}
+	sort.Slice(bts, func(a, b int) bool {
+		return bts[a].Build.FinishTime().After(bts[b].Build.FinishTime())
+	})
+
+	feed := atomFeed{
+		Title:   "Latest builds",
+		ID:      tc.base + "/",
+		Updated: c.updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: tc.base + "/"},
+	}
+	for _, bt := range bts {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   fmt.Sprintf("%s / %s #%s", bt.ProjectName, bt.Name, bt.Build.Number),
+			ID:      bt.Build.WebURL,
+			Updated: bt.Build.FinishTime().UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: bt.Build.WebURL},
+			Summary: bt.Build.StatusText,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		errorln("Encoding feed:", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A pageFilter narrows the page down to the projects, branch and build
+// status given as query parameters.
+type pageFilter struct {
+	project string
+	branch  string
+	status  string
+}
+
+// pageFilterFrom returns the filter given in the request. Matching ignores
+// case, so the values are lower cased to share cached renders.
+func pageFilterFrom(req *http.Request) pageFilter {
+	q := req.URL.Query()
+	param := func(name string) string {
+		return strings.ToLower(strings.TrimSpace(q.Get(name)))
+	}
+	return pageFilter{
+		project: param("project"),
+		branch:  param("branch"),
+		status:  param("status"),
+	}
+}
+
+func (f pageFilter) empty() bool {
+	return f == pageFilter{}
+}
+
+// apply returns the projects with only the matching builds. A project
+// matches by name or ID, including its subprojects. A status matches the
+// build status, such as FAILURE, or its state, such as running. Matching
+// ignores case.
+func (f pageFilter) apply(projs []project) []project {
+	var res []project
+	for _, p := range projs {
+		if f.project != "" && !strings.EqualFold(p.ID, f.project) && !strings.EqualFold(p.Name, f.project) &&
+			!strings.HasPrefix(strings.ToLower(p.Name), strings.ToLower(f.project)+" / ") {
+			continue
+		}
+
+		var bts []buildType
+		p.TotalSize = 0
+		for _, bt := range p.Builds {
+			if f.branch != "" && !strings.EqualFold(bt.Build.BranchName, f.branch) {
+				continue
+			}
+			if f.status != "" && !strings.EqualFold(bt.Build.Status, f.status) && !strings.EqualFold(bt.Build.State, f.status) {
+				continue
+			}
+			bts = append(bts, bt)
+			p.TotalSize += bt.Build.TotalSize
+		}
+		p.Builds = bts
+		res = append(res, p)
+	}
+
+	// Parents stay visible as long as something below them is
+	for i := len(res) - 1; i >= 0; i-- {
+		res[i].subtreeVisible = false
+		for _, bt := range res[i].Builds {
+			if bt.Visible() {
+				res[i].subtreeVisible = true
+				break
+			}
+		}
+		for j := i + 1; j < len(res) && res[j].Depth > res[i].Depth; j++ {
+			if res[j].subtreeVisible {
+				res[i].subtreeVisible = true
+				break
+			}
+		}
+	}
+	return res
+}
+
+// maxPageVariants bounds the number of filtered renders kept per refresh.
+const maxPageVariants = 32
+
+// A pageVariant is the page rendered for a filter.
+type pageVariant struct {
+	data   []byte
+	dataGz []byte
+	etag   string
+}
+
+// variantCache holds the filtered renders of one cache entry, so they are
+// dropped with it on the next refresh. The least recently used variant is
+// evicted when full.
+type variantCache struct {
+	mut     sync.Mutex
+	entries *lru[pageFilter, *pageVariant]
+}
+
+// variant returns the page for the filter, rendering it on first use.
+func (c *cacheEntry) variant(f pageFilter) (*pageVariant, error) {
+	vc := &c.variants
+	vc.mut.Lock()
+	defer vc.mut.Unlock()
+
+	if vc.entries == nil {
+		vc.entries = newLRU[pageFilter, *pageVariant](maxPageVariants)
+	}
+	if v, ok := vc.entries.get(f); ok {
+		return v, nil
+	}
+
+	data, _, err := getTpl(f.apply(c.projects), c.loadErrs, c.updated)
+	if err != nil {
+		return nil, err
+	}
+	// Compression failures only mean serving uncompressed
+	dataGz, _ := gzipBytes(data)
+	v := &pageVariant{data: data, dataGz: dataGz, etag: etagFor(data)}
+
+	vc.entries.put(f, v)
+	return v, nil
+}
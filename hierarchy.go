@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// rootProjectID is the ID of TeamCity's implicit top level project.
+const rootProjectID = "_Root"
+
+type projectListResponse struct {
+	Count    int
+	NextHRef string
+	Projects []projectInfo `json:"project"`
+}
+
+type projectInfo struct {
+	ID              string
+	Name            string
+	ParentProjectID string
+}
+
+func getProjectInfos(ctx context.Context, srv int) ([]projectInfo, error) {
+	return server(srv).Projects(ctx)
+}
+
+// Projects returns all projects on the server, with their parents.
+func (c *Client) Projects(ctx context.Context) ([]projectInfo, error) {
+	url := "/app/rest/projects?fields=count,nextHref,project(id,name,parentProjectId)"
+	var infos []projectInfo
+	for url != "" {
+		var res projectListResponse
+		if err := c.getJSON(ctx, url, &res); err != nil {
+			return nil, errors.Wrap(err, "get projects")
+		}
+		infos = append(infos, res.Projects...)
+		url = res.NextHRef
+	}
+	return infos, nil
+}
+
+// nestProjects orders the projects as a depth first walk of the project
+// tree, setting the depth of each. Ancestors without build types of their
+// own are added so that the tree is complete, up to the root project or
+// the configured top level project.
+func nestProjects(projs []project, infos []projectInfo) []project {
+	byID := make(map[string]projectInfo, len(infos))
+	for _, info := range infos {
+		byID[info.ID] = info
+	}
+	have := make(map[string]project, len(projs))
+	for _, p := range projs {
+		have[p.ID] = p
+	}
+
+	// Find all projects to include, and the children of each
+	include := make(map[string]bool)
+	children := make(map[string][]string)
+	var roots []string
+	for _, p := range projs {
+		for id := p.ID; !include[id]; {
+			include[id] = true
+			parent, ok := byID[id]
+			if !ok || parent.ParentProjectID == rootProjectID || id == projectName {
+				roots = append(roots, id)
+				break
+			}
+			children[parent.ParentProjectID] = append(children[parent.ParentProjectID], id)
+			id = parent.ParentProjectID
+		}
+	}
+
+	byName := func(ids []string) {
+		sort.Slice(ids, func(a, b int) bool {
+			return naturalLess(byID[ids[a]].Name, byID[ids[b]].Name)
+		})
+	}
+
+	var res []project
+	var walk func(id, fullName string, depth int) bool
+	walk = func(id, fullName string, depth int) bool {
+		p, ok := have[id]
+		if !ok {
+			p = project{ID: id, Name: fullName}
+		}
+		if info, ok := byID[id]; ok {
+			p.ShortName = info.Name
+		}
+		p.Depth = depth
+
+		idx := len(res)
+		res = append(res, p)
+
+		visible := p.Visible()
+		kids := children[id]
+		byName(kids)
+		for _, kid := range kids {
+			if walk(kid, p.Name+" / "+byID[kid].Name, depth+1) {
+				visible = true
+			}
+		}
+		res[idx].subtreeVisible = visible
+		return visible
+	}
+
+	byName(roots)
+	for _, id := range roots {
+		name := have[id].Name
+		if name == "" {
+			name = byID[id].Name
+		}
+		walk(id, name, 0)
+	}
+
+	return res
+}
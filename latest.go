@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// latestHandler redirects /latest/{buildTypeID}/{filename} to the matching
+// artifact of the latest build on the page, if it finished successfully.
+// The file name may be a glob pattern such as "app-*.zip"; failing an
+// exact or pattern match, the first file containing the name as a
+// substring is used.
+func latestHandler(w http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/latest/"), "/", 2)
+	srv, ok := serverParam(req)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || !ok {
+		http.NotFound(w, req)
+		return
+	}
+	id, name := parts[0], parts[1]
+
+	b, ok := cachedBuild(srv, id)
+	if !ok || b.State != "finished" || b.Status != "SUCCESS" {
+		http.NotFound(w, req)
+		return
+	}
+
+	f, ok := matchFile(b.Files, name)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	u := downloadURL(b.Server, b.ID, f)
+	if !strings.Contains(u, "://") {
+		// Relative proxy link
+		u = "/" + u
+	}
+	http.Redirect(w, req, u, http.StatusFound)
+}
+
+func matchFile(files []file, pattern string) (file, bool) {
+	for _, f := range files {
+		if ok, _ := path.Match(pattern, f.Name); ok {
+			return f, true
+		}
+	}
+	for _, f := range files {
+		if strings.Contains(f.Name, pattern) {
+			return f, true
+		}
+	}
+	return file{}, false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLatestRedirect(t *testing.T) {
+	srv := useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("fetched %s from TeamCity", req.URL.Path)
+		http.NotFound(w, req)
+	}))
+	var files []file
+	for _, name := range []string{"app-linux.tar.gz", "app-windows.zip"} {
+		f := file{Name: name}
+		f.Content.HRef = "/guestAuth/app/rest/builds/id:11/artifacts/content/" + name
+		files = append(files, f)
+	}
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{
+		{ID: "A_Build", Build: build{ID: 11, State: "finished", Status: "SUCCESS", Files: files}},
+		{ID: "B_Failed", Build: build{ID: 12, State: "finished", Status: "FAILURE", Files: files}},
+		{ID: "C_Running", Build: build{ID: 13, State: "running", Status: "SUCCESS", Files: files}},
+	}}}})
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/latest/A_Build/app-*.zip", http.StatusFound},
+		{"/latest/A_Build/linux", http.StatusFound},
+		{"/latest/A_Build/app.dmg", http.StatusNotFound},
+		{"/latest/B_Failed/app-*.zip", http.StatusNotFound},
+		{"/latest/C_Running/app-*.zip", http.StatusNotFound},
+		{"/latest/D_Unlisted/app-*.zip", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		latestHandler(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: got status %d, expected %d", c.path, rec.Code, c.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	latestHandler(rec, httptest.NewRequest(http.MethodGet, "/latest/A_Build/app-*.zip", nil))
+	if loc, want := rec.Header().Get("Location"), srv.URL+files[1].Content.HRef; loc != want {
+		t.Errorf("got redirect to %q, expected %q", loc, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// jsonLogs is set when logging structured JSON rather than plain text.
+	jsonLogs bool
+
+	// Messages below this level are discarded.
+	logLevel = slog.LevelInfo
+)
+
+func setupLogging(format, level string) error {
+	switch strings.ToLower(level) {
+	case "debug":
+		logLevel = slog.LevelDebug
+	case "info":
+		logLevel = slog.LevelInfo
+	case "warn":
+		logLevel = slog.LevelWarn
+	case "error":
+		logLevel = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+
+	switch format {
+	case "text":
+	case "json":
+		jsonLogs = true
+		// Also routes the plain log package through the JSON handler
+		opts := &slog.HandlerOptions{Level: logLevel}
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+// logEvent logs msg as is in text mode, or as a structured record carrying
+// the event name and the given key-value attributes in JSON mode.
+func logEvent(level slog.Level, event, msg string, attrs ...any) {
+	logAt(level, msg, append([]any{"event", event}, attrs...)...)
+}
+
+func logAt(level slog.Level, msg string, attrs ...any) {
+	if level < logLevel {
+		return
+	}
+	if !jsonLogs {
+		log.Println(msg)
+		return
+	}
+	slog.Log(context.Background(), level, msg, attrs...)
+}
+
+// The following log their arguments like log.Println, at the given level.
+
+func debugln(v ...any) {
+	logAt(slog.LevelDebug, sprintln(v...))
+}
+
+func infoln(v ...any) {
+	logAt(slog.LevelInfo, sprintln(v...))
+}
+
+func warnln(v ...any) {
+	logAt(slog.LevelWarn, sprintln(v...))
+}
+
+func errorln(v ...any) {
+	logAt(slog.LevelError, sprintln(v...))
+}
+
+func sprintln(v ...any) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
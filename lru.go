@@ -0,0 +1,47 @@
+package main
+
+// An lru is a map holding at most max entries, evicting the least recently
+// used one when full. It is not safe for concurrent use.
+type lru[K comparable, V any] struct {
+	max     int
+	entries map[K]V
+	order   []K // least recently used first
+}
+
+func newLRU[K comparable, V any](max int) *lru[K, V] {
+	return &lru[K, V]{max: max, entries: make(map[K]V)}
+}
+
+// get returns the value for the key, marking it as the most recently used.
+func (l *lru[K, V]) get(key K) (V, bool) {
+	v, ok := l.entries[key]
+	if ok {
+		l.touch(key)
+	}
+	return v, ok
+}
+
+// put sets the value for the key, marking it as the most recently used.
+func (l *lru[K, V]) put(key K, v V) {
+	if _, ok := l.entries[key]; ok {
+		l.touch(key)
+	} else {
+		if len(l.order) >= l.max {
+			delete(l.entries, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, key)
+	}
+	l.entries[key] = v
+}
+
+// touch moves the key to the back of the order.
+func (l *lru[K, V]) touch(key K) {
+	for i, k := range l.order {
+		if k == key {
+			copy(l.order[i:], l.order[i+1:])
+			l.order[len(l.order)-1] = key
+			return
+		}
+	}
+}
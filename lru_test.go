@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newLRU[string, int](2)
+	l.put("a", 1)
+	l.put("b", 2)
+	l.get("a")
+	l.put("c", 3) // evicts b, as a was used more recently
+
+	if _, ok := l.get("b"); ok {
+		t.Error("b not evicted")
+	}
+	for k, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := l.get(k); !ok || v != want {
+			t.Errorf("get(%q) = %d, %v; expected %d", k, v, ok, want)
+		}
+	}
+
+	l.put("c", 4) // replacing doesn't evict
+	if v, _ := l.get("c"); v != 4 || len(l.entries) != 2 {
+		t.Errorf("got c=%d with %d entries, expected c=4 with 2", v, len(l.entries))
+	}
+}
@@ -2,165 +2,1744 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	_ "embed"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	// maxConcurrency caps -concurrency to avoid overwhelming TeamCity.
+	maxConcurrency = 32
+
+	// shutdownTimeout is how long we wait for in flight requests when
+	// shutting down.
+	shutdownTimeout = 10 * time.Second
+
+	// maxPageAge caps how long clients may cache the page, since webhooks
+	// and /refresh/ update it between scheduled refreshes.
+	maxPageAge = time.Minute
+
+	// tcTimeFormat is the format of dates in TeamCity responses.
+	tcTimeFormat = "20060102T150405-0700"
+)
+
+// Version is set at build time with -ldflags "-X main.Version=...".
+var Version = "unknown"
+
+//go:embed template.html
+var defaultTemplate string
+
 var (
-	base         = "https://build.kastelo.net"
-	branch       = "master"
-	listen       = "127.0.0.1:8123"
-	auth         = ""
-	maxCacheTime = 24 * time.Hour
-	projectName  = ""
-	templateFile = "template.html"
-	tpl          *template.Template
+	base               = "https://build.kastelo.net"
+	branch             = "master"
+	branches           []string
+	listen             = "127.0.0.1:8123"
+	auth               = ""
+	token              = ""
+	authMode           = "auto"
+	maxCacheTime       = 24 * time.Hour
+	minRefreshInterval = 10 * time.Second
+	projectName        = ""
+	templateFile       = ""
+	httpTimeout        = 30 * time.Second
+	retries            = 3
+	breakerFailures    = 5
+	breakerCooldown    = time.Minute
+	concurrency        = 8
+	buildStatus        = "SUCCESS"
+	withRunning        = false
+	pinnedOnly         = false
+	buildTag           = ""
+	checksums          = false
+	noCacheHdrs        = false
+	configFile         = ""
+	logFormat          = "text"
+	logLevelStr        = "info"
+	caCertFile         = ""
+	proxy              = ""
+	userAgent          = ""
+	insecure           = false
+	tlsCert            = ""
+	tlsKey             = ""
+	pageAuth           = ""
+	csp                = ""
+	webhookSecret      = ""
+	commitURL          = ""
+	maxChanges         = 5
+	showTests          = false
+	history            = 1
+	maxAge             time.Duration
+	showEmpty          = false
+	maxArtifacts       = 0
+	useCDN             = false
+	theme              = "light"
+	collapsed          = false
+	autoRefresh        time.Duration
+	cacheFile          = ""
+	warm               = false
+	warmTimeout        = time.Minute
+	mirrorDir          = ""
+	mirrorKeep         = 0
+	s3Endpoint         = ""
+	s3Bucket           = ""
+	s3Region           = "us-east-1"
+	s3AccessKey        = ""
+	s3SecretKey        = ""
+	s3PublicURL        = ""
+	slackWebhook       = ""
+	slackTemplate      = defaultSlackTemplate
+	digestInterval     time.Duration
+	smtpServer         = ""
+	smtpFrom           = ""
+	smtpTo             = ""
+	smtpAuth           = ""
+	publicURL          = ""
+	footer             = ""
+	logo               = ""
+	timezone           = ""
+	displayLoc         = time.UTC
+	flat               = false
+	include            = ""
+	exclude            = ""
+	artifactExclude    = `(^|/)\.`
+	hideEmpty          = true
+	platforms          = defaultPlatforms
+	archs              = defaultArchs
+	statusColorsStr    = ""
+	includeRe          *regexp.Regexp
+	excludeRe          *regexp.Regexp
+	artifactExcludeRe  *regexp.Regexp
+	tpl                *template.Template
+	tc                 = &Client{httpClient: http.DefaultClient}
+	servers            = []*Client{tc}
 )
 
 func main() {
-	flag.StringVar(&base, "base", base, "TeamCity server address")
-	flag.StringVar(&branch, "branch", branch, "Branch to show")
+	flag.StringVar(&base, "base", base, "TeamCity server addresses (comma separated)")
+	flag.StringVar(&branch, "branch", branch, "Branches to show (comma separated): branch names, * for any branch, default for each build type's default branch, or TeamCity branch locators such as default:any or name:release-1.x")
 	flag.StringVar(&listen, "listen", listen, "Server listen address")
 	flag.StringVar(&projectName, "project", projectName, "Top level project")
-	flag.StringVar(&auth, "auth", auth, "username:password")
-	flag.DurationVar(&maxCacheTime, "cache", maxCacheTime, "Cache life time")
-	flag.StringVar(&templateFile, "template-file", templateFile, "Path to template file")
+	flag.StringVar(&auth, "auth", auth, "username:password, comma separated per -base (prefer TCBUILDS_AUTH to keep it out of the process list)")
+	flag.StringVar(&token, "token", token, "TeamCity access token, comma separated per -base (prefer TCBUILDS_TOKEN to keep it out of the process list)")
+	flag.StringVar(&authMode, "auth-mode", authMode, "TeamCity URL prefix: guest for /guestAuth, http for /httpAuth, or auto to use /httpAuth when credentials are given")
+	flag.DurationVar(&maxCacheTime, "cache", maxCacheTime, "Cache life time (0 to disable periodic refresh)")
+	flag.StringVar(&cacheFile, "cache-file", cacheFile, "Path to save the page to after each refresh, and serve from at startup")
+	flag.BoolVar(&warm, "warm", warm, "Refresh the cache before starting to listen")
+	flag.DurationVar(&warmTimeout, "warm-timeout", warmTimeout, "How long -warm waits for the first refresh before listening anyway")
+	flag.StringVar(&mirrorDir, "mirror-dir", mirrorDir, "Directory to download the latest artifacts to, and serve them from under /mirror/")
+	flag.IntVar(&mirrorKeep, "mirror-keep", mirrorKeep, "Number of builds per build type to keep in -mirror-dir (0 to keep all)")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", s3Endpoint, "S3 compatible endpoint to upload the latest artifacts to, such as https://s3.eu-west-1.amazonaws.com")
+	flag.StringVar(&s3Bucket, "s3-bucket", s3Bucket, "S3 bucket to upload the latest artifacts to")
+	flag.StringVar(&s3Region, "s3-region", s3Region, "S3 region")
+	flag.StringVar(&s3AccessKey, "s3-access-key", s3AccessKey, "S3 access key (default from AWS_ACCESS_KEY_ID)")
+	flag.StringVar(&s3SecretKey, "s3-secret-key", s3SecretKey, "S3 secret key (default from AWS_SECRET_ACCESS_KEY; prefer TCBUILDS_S3_SECRET_KEY)")
+	flag.StringVar(&s3PublicURL, "s3-public-url", s3PublicURL, "Public URL of the bucket, to link to the uploaded artifacts instead of TeamCity")
+	flag.StringVar(&slackWebhook, "slack-webhook", slackWebhook, "Slack incoming webhook URL to announce new builds to (prefer TCBUILDS_SLACK_WEBHOOK)")
+	flag.StringVar(&slackTemplate, "slack-template", slackTemplate, "Go template for Slack messages, with .Project, .BuildType, .Number, .Branch, .Status, .StatusText and .URL")
+	flag.DurationVar(&digestInterval, "digest-interval", digestInterval, "Email a digest of the builds finished in each interval, such as 24h (0 to disable)")
+	flag.StringVar(&smtpServer, "smtp-server", smtpServer, "SMTP server for the digest, as host:port")
+	flag.StringVar(&smtpFrom, "smtp-from", smtpFrom, "Sender address of the digest")
+	flag.StringVar(&smtpTo, "smtp-to", smtpTo, "Recipient addresses of the digest (comma separated)")
+	flag.StringVar(&smtpAuth, "smtp-auth", smtpAuth, "username:password for the SMTP server, if required (prefer TCBUILDS_SMTP_AUTH)")
+	flag.StringVar(&publicURL, "public-url", publicURL, "URL this page is reachable at, for links to it in emails")
+	flag.DurationVar(&minRefreshInterval, "min-refresh-interval", minRefreshInterval, "Minimum time between refreshes requested via /refresh/ (0 for no limit)")
+	flag.StringVar(&templateFile, "template-file", templateFile, "Path to template file (default built in)")
+	flag.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for TeamCity requests")
+	flag.IntVar(&retries, "retries", retries, "Number of retries for failed TeamCity requests")
+	flag.IntVar(&breakerFailures, "breaker-failures", breakerFailures, "Pause TeamCity requests after this many consecutive failures (0 to never pause)")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", breakerCooldown, "How long to pause TeamCity requests after -breaker-failures")
+	flag.IntVar(&concurrency, "concurrency", concurrency, "Number of concurrent TeamCity requests")
+	flag.StringVar(&buildStatus, "status", buildStatus, "Build status to show (SUCCESS, FAILURE or any)")
+	flag.BoolVar(&withRunning, "include-running", withRunning, "Include running builds")
+	flag.BoolVar(&pinnedOnly, "pinned-only", pinnedOnly, "Show only pinned builds")
+	flag.StringVar(&buildTag, "tag", buildTag, "Show only builds with this tag")
+	flag.BoolVar(&checksums, "checksums", checksums, "Compute SHA-256 checksums of artifacts")
+	flag.BoolVar(&noCacheHdrs, "no-cache-headers", noCacheHdrs, "Don't set Cache-Control headers")
+	flag.StringVar(&caCertFile, "ca-cert", caCertFile, "Path to PEM file with additional CA certificates for TeamCity")
+	flag.StringVar(&proxy, "proxy", proxy, "Proxy URL for TeamCity requests (default from HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	flag.StringVar(&userAgent, "user-agent", userAgent, "User-Agent for TeamCity requests (default tcbuilds/<version>)")
+	flag.BoolVar(&insecure, "insecure", insecure, "Skip TeamCity certificate verification (not recommended)")
+	flag.StringVar(&tlsCert, "tls-cert", tlsCert, "Path to TLS certificate, to serve HTTPS")
+	flag.StringVar(&tlsKey, "tls-key", tlsKey, "Path to TLS key, to serve HTTPS")
+	flag.StringVar(&pageAuth, "page-auth", pageAuth, "username:password required to view the page (prefer TCBUILDS_PAGE_AUTH)")
+	flag.StringVar(&csp, "csp", csp, "Content-Security-Policy header, \"none\" to not send one (default allows the built in page)")
+	flag.StringVar(&webhookSecret, "webhook-secret", webhookSecret, "Secret required in the "+webhookSecretHeader+" header of webhook requests (prefer TCBUILDS_WEBHOOK_SECRET)")
+	flag.StringVar(&commitURL, "commit-url", commitURL, "Link for build commits, with %s for the revision (e.g. https://github.com/org/repo/commit/%s)")
+	flag.IntVar(&maxArtifacts, "max-artifacts", maxArtifacts, "Number of artifacts to list per build on the page (0 for all)")
+	flag.StringVar(&artifactExclude, "artifact-exclude", artifactExclude, "Hide artifacts whose path matches this regexp, such as dot files and directories")
+	flag.StringVar(&platforms, "platforms", platforms, "Group artifacts by platform, as name=token,token;... matched against file names (empty to not group)")
+	flag.StringVar(&archs, "archs", archs, "Divide the platform groups by architecture, as name=token,token;... matched against file names (empty to not divide)")
+	flag.BoolVar(&hideEmpty, "hide-empty-artifacts", hideEmpty, "Hide zero byte artifacts")
+	flag.IntVar(&history, "history", history, "Number of builds to show per build type, including the latest")
+	flag.IntVar(&maxChanges, "changes", maxChanges, "Number of recent changes to list per build (0 to disable)")
+	flag.StringVar(&statusColorsStr, "status-colors", statusColorsStr, "Colors for build states and statuses, as status=class or status=#rrggbb,... with Bootstrap text classes such as success or muted, overriding the defaults "+defaultStatusColors)
+	flag.BoolVar(&showTests, "tests", showTests, "Show test counts per build (one more TeamCity request per build)")
+	flag.BoolVar(&useCDN, "cdn", useCDN, "Load Bootstrap from its CDN instead of the built in stylesheet")
+	flag.DurationVar(&autoRefresh, "auto-refresh", autoRefresh, "Make browsers reload the page at this interval (0 to disable)")
+	flag.BoolVar(&collapsed, "collapsed-by-default", collapsed, "Show projects collapsed until opened")
+	flag.StringVar(&theme, "theme", theme, "Page theme (light, dark, or auto to follow the browser)")
+	flag.StringVar(&footer, "footer", footer, "Text for the page footer, in which URLs become links (default credits tcbuilds)")
+	flag.StringVar(&logo, "logo", logo, "URL or path to an image shown next to the page title")
+	flag.StringVar(&timezone, "timezone", timezone, "Time zone for displayed dates, such as Europe/Stockholm (default UTC)")
+	flag.BoolVar(&flat, "flat", flat, "Show a flat project list instead of the project hierarchy")
+	flag.BoolVar(&showEmpty, "show-empty", showEmpty, "Show build types that have no matching build, with their latest build of any status")
+	flag.DurationVar(&maxAge, "max-age", maxAge, "Hide builds that finished longer ago than this (0 to show all)")
+	flag.StringVar(&include, "include", include, "Only show build types whose name or project name matches this regexp")
+	flag.StringVar(&exclude, "exclude", exclude, "Hide build types whose name or project name matches this regexp")
+	flag.StringVar(&logFormat, "log-format", logFormat, "Log format (text or json)")
+	flag.StringVar(&logLevelStr, "log-level", logLevelStr, "Log level (debug, info, warn or error)")
+	flag.StringVar(&configFile, "config", configFile, "Path to YAML or JSON config file, keyed by flag name")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 	flag.Parse()
 
-	var err error
-	tpl, err = template.New(filepath.Base(templateFile)).ParseFiles(templateFile)
+	if *showVersion {
+		fmt.Println("tcbuilds", Version)
+		return
+	}
+
+	envUsed, err := loadEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if configFile != "" {
+		if err := loadConfig(configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// After the environment and config file, which may set the log options
+	if err := setupLogging(logFormat, logLevelStr); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	infoln("Settings are taken from flags, then " + envPrefix + "* environment variables, then the config file, then defaults")
+	for _, env := range envUsed {
+		infoln("Using", env, "from environment")
+	}
+
+	if _, port, err := net.SplitHostPort(listen); err != nil {
+		fmt.Println("Invalid listen address:", err)
+		os.Exit(1)
+	} else if _, err := net.LookupPort("tcp", port); err != nil {
+		fmt.Println("Invalid listen address:", err)
+		os.Exit(1)
+	}
+
+	if (tlsCert == "") != (tlsKey == "") {
+		fmt.Println("Both -tls-cert and -tls-key must be given to serve HTTPS")
+		os.Exit(1)
+	}
+
+	bases := splitList(base)
+	auths := splitPerServer(auth)
+	tokens := splitPerServer(token)
+	if len(bases) == 0 {
+		fmt.Println("No -base given")
+		os.Exit(1)
+	}
+	if len(auths) > 1 && len(auths) != len(bases) || len(tokens) > 1 && len(tokens) != len(bases) {
+		fmt.Println("-auth and -token must have one entry, or one per -base")
+		os.Exit(1)
+	}
+	for _, b := range bases {
+		if err := checkBaseURL(b); err != nil {
+			fmt.Println("Invalid -base:", err)
+			os.Exit(1)
+		}
+	}
+	for _, a := range auths {
+		if user, _, ok := strings.Cut(a, ":"); a != "" && (!ok || user == "") {
+			fmt.Println("Invalid -auth: expected username:password")
+			os.Exit(1)
+		}
+	}
+
+	buildStatus = strings.ToUpper(buildStatus)
+	switch buildStatus {
+	case "SUCCESS", "FAILURE", "ANY":
+	default:
+		fmt.Printf("Unknown -status %q, expected SUCCESS, FAILURE or any\n", buildStatus)
+		os.Exit(1)
+	}
+	if userAgent == "" {
+		userAgent = "tcbuilds/" + Version
+	}
+
+	switch authMode {
+	case "auto", "guest", "http":
+	default:
+		fmt.Println("Unknown -auth-mode:", authMode)
+		os.Exit(1)
+	}
+
+	switch theme {
+	case "light", "dark", "auto":
+	default:
+		fmt.Println("Unknown -theme:", theme)
+		os.Exit(1)
+	}
+
+	if logo != "" && !isRemoteLogo(logo) {
+		if _, err := os.Stat(logo); err != nil {
+			fmt.Println("Logo:", err)
+			os.Exit(1)
+		}
+	}
+
+	switch csp {
+	case "":
+		csp = defaultCSP()
+	case "none":
+		csp = ""
+	}
+
+	if s3Bucket != "" {
+		if err := checkBaseURL(s3Endpoint); err != nil {
+			fmt.Println("Invalid -s3-endpoint:", err)
+			os.Exit(1)
+		}
+		if s3AccessKey == "" {
+			s3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if s3SecretKey == "" {
+			s3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		if s3AccessKey == "" || s3SecretKey == "" {
+			fmt.Println("-s3-bucket requires -s3-access-key and -s3-secret-key")
+			os.Exit(1)
+		}
+	}
+
+	if slackWebhook != "" {
+		if err := checkBaseURL(slackWebhook); err != nil {
+			fmt.Println("Invalid -slack-webhook:", err)
+			os.Exit(1)
+		}
+		if err := parseSlackTemplate(slackTemplate); err != nil {
+			fmt.Println("Invalid -slack-template:", err)
+			os.Exit(1)
+		}
+	}
+
+	if digestInterval > 0 {
+		if _, _, err := net.SplitHostPort(smtpServer); err != nil {
+			fmt.Println("Invalid -smtp-server:", err)
+			os.Exit(1)
+		}
+		if smtpFrom == "" || len(splitList(smtpTo)) == 0 {
+			fmt.Println("-digest-interval requires -smtp-from and -smtp-to")
+			os.Exit(1)
+		}
+	}
+
+	if commitURL != "" && strings.Count(commitURL, "%s") != 1 {
+		fmt.Printf("-commit-url must contain exactly one %%s, got %q\n", commitURL)
+		os.Exit(1)
+	}
+
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err != nil {
+			warnln("Using UTC:", err)
+		} else {
+			displayLoc = loc
+		}
+	}
+
+	transport, err := newTransport(caCertFile, insecure, proxy)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if insecure {
+		warnln("WARNING: TeamCity certificate verification is disabled (-insecure)")
+	}
+
+	if include != "" {
+		if includeRe, err = regexp.Compile(include); err != nil {
+			fmt.Println("Invalid -include:", err)
+			os.Exit(1)
+		}
+	}
+	if exclude != "" {
+		if excludeRe, err = regexp.Compile(exclude); err != nil {
+			fmt.Println("Invalid -exclude:", err)
+			os.Exit(1)
+		}
+	}
+	if artifactExclude != "" {
+		if artifactExcludeRe, err = regexp.Compile(artifactExclude); err != nil {
+			fmt.Println("Invalid -artifact-exclude:", err)
+			os.Exit(1)
+		}
+	}
+
+	servers = nil
+	httpClient := &http.Client{Timeout: httpTimeout, Transport: transport}
+	for i, b := range bases {
+		c := &Client{
+			base:       strings.TrimSuffix(b, "/"),
+			auth:       listEntry(auths, i),
+			token:      listEntry(tokens, i),
+			agent:      userAgent,
+			authMode:   authMode,
+			project:    projectName,
+			status:     buildStatus,
+			running:    withRunning,
+			pinned:     pinnedOnly,
+			tag:        buildTag,
+			history:    history,
+			retries:    retries,
+			retryDelay: time.Second,
+			httpClient: httpClient,
+			breaker:    newBreaker(breakerFailures, breakerCooldown),
+		}
+		if c.auth != "" && c.token != "" {
+			fmt.Println("Only one of -auth and -token may be given for", c.base)
+			os.Exit(1)
+		}
+		servers = append(servers, c)
+
+		if u := proxyFor(transport, c.base); u != nil {
+			infoln("Using proxy", u.Redacted(), "for", c.base)
+		} else {
+			infoln("Not using a proxy for", c.base)
+		}
+	}
+	tc = servers[0]
+
+	if platformGroups, err = parsePlatforms(platforms); err != nil {
+		fmt.Println("Invalid -platforms:", err)
+		os.Exit(1)
+	}
+	if archGroups, err = parsePlatforms(archs); err != nil {
+		fmt.Println("Invalid -archs:", err)
+		os.Exit(1)
+	}
+
+	if statusColors, err = parseStatusColors(statusColorsStr); err != nil {
+		fmt.Println("Invalid -status-colors:", err)
+		os.Exit(1)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	} else if concurrency > maxConcurrency {
+		concurrency = maxConcurrency
+	}
+
+	branches = splitList(branch)
+	if len(branches) == 0 {
+		fmt.Println("No branch given")
+		os.Exit(1)
+	}
+	for _, b := range branches {
+		if err := checkBranch(b); err != nil {
+			fmt.Println("Invalid -branch:", err)
+			os.Exit(1)
+		}
+	}
+	if strings.ContainsAny(buildTag, ",()") || strings.TrimSpace(buildTag) != buildTag {
+		fmt.Println("Invalid -tag:", buildTag)
+		os.Exit(1)
+	}
+
+	logConfig()
+
+	if templateFile != "" {
+		tpl, err = template.New(filepath.Base(templateFile)).Funcs(templateFuncs).ParseFiles(templateFile)
+	} else {
+		tpl, err = template.New("template.html").Funcs(templateFuncs).Parse(defaultTemplate)
+	}
 	if err != nil {
 		fmt.Println("Parsing template:", err)
 		os.Exit(1)
 	}
 
-	http.HandleFunc("/", handler)
-	http.HandleFunc("/refresh/", refresh)
+	http.HandleFunc("/", handler)
+	http.HandleFunc("/refresh/", refresh)
+	http.HandleFunc("/api/builds", apiBuilds)
+	http.HandleFunc("/api/status", apiStatus)
+	http.Handle("/metrics", metricsHandler())
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", readyz)
+	http.HandleFunc("/zip/", zipHandler)
+	http.HandleFunc("/artifacts/", artifactsHandler)
+	http.HandleFunc("/build/", buildHandler)
+	http.HandleFunc("/compare", compareHandler)
+	http.HandleFunc("/badge/", badgeHandler)
+	http.HandleFunc("/latest/", latestHandler)
+	http.HandleFunc("/download/", downloadHandler)
+	http.HandleFunc("/feed.atom", feedHandler)
+	http.HandleFunc("/md", mdHandler)
+	http.HandleFunc("/csv", csvHandler)
+	http.HandleFunc("/webhook", webhookHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.Handle("/assets/", assetsHandler())
+	if logo != "" && !isRemoteLogo(logo) {
+		http.HandleFunc("/logo", logoHandler)
+	}
+	if mirrorDir != "" {
+		http.Handle("/mirror/", mirrorHandler())
+	}
+
+	srv := &http.Server{
+		Addr:    listen,
+		Handler: withRecover(withSecurityHeaders(csp, withPageAuth(pageAuth, http.DefaultServeMux))),
+	}
+	if tlsCert != "" {
+		certs, err := newCertReloader(tlsCert, tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+
+	if cacheFile != "" {
+		// Serve the last saved page until the first refresh is done
+		if c, err := loadCacheFile(cacheFile); err != nil {
+			if !os.IsNotExist(errors.Cause(err)) {
+				warnln(err)
+			}
+		} else {
+			infoln("Loaded cache from", cacheFile, "refreshed", c.updated.Format(time.RFC3339))
+			cache.Store(c)
+		}
+	}
+
+	warmed := false
+	if warm {
+		// Only become reachable once there is something to serve
+		infoln("Warming the cache before listening")
+		before := currentCache()
+		ctx, cancel := context.WithTimeout(appCtx, warmTimeout)
+		runRefresh(ctx, nil)
+		cancel()
+		warmed = currentCache() != before
+		if !warmed {
+			warnln("Could not warm the cache within", warmTimeout, "- listening anyway")
+		}
+	}
+
+	// Listen before starting the refresh so that a busy port is reported
+	// right away.
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	refreshDone := make(chan struct{})
+	go func() {
+		refreshLoop(appCtx)
+		close(refreshDone)
+	}()
+	if !warmed {
+		requestRefresh("")
+	}
+
+	if digestInterval > 0 {
+		go digestLoop(appCtx)
+	}
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigs
+		infoln("Received", sig, "- shutting down")
+
+		// Abort any refresh in progress and let in flight requests finish.
+		appCancel()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			errorln("Shutdown:", err)
+		}
+	}()
+
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	<-refreshDone
+	infoln("Shutdown complete")
+}
+
+var (
+	// appCtx is cancelled when the process is shutting down.
+	appCtx, appCancel = context.WithCancel(context.Background())
+
+	refreshRequests = make(chan struct{}, 1)
+
+	// cache holds the *cacheEntry from the last successful refresh. It's
+	// swapped atomically so readers never wait for a refresh.
+	cache atomic.Value
+
+	// fullRefreshRunning is set while everything is being reloaded.
+	fullRefreshRunning atomic.Bool
+
+	// lastManualRefresh is the time of the last accepted /refresh/
+	// request, in Unix nanoseconds.
+	lastManualRefresh atomic.Int64
+
+	// nextRefresh is the time of the next scheduled refresh, in Unix
+	// nanoseconds, or zero when periodic refresh is disabled.
+	nextRefresh atomic.Int64
+)
+
+// A cacheEntry is the result of a refresh. It must not be modified once
+// stored in the cache.
+type cacheEntry struct {
+	data     []byte
+	dataGz   []byte
+	dataETag string
+	json     []byte
+	jsonGz   []byte
+	jsonETag string
+	projects []project
+	loadErrs []loadError
+	results  []buildResult
+	updated  time.Time
+	variants variantCache // filtered renders of the page
+}
+
+// currentCache returns the current cache entry, or nil if the cache hasn't
+// been populated yet.
+func currentCache() *cacheEntry {
+	c, _ := cache.Load().(*cacheEntry)
+	return c
+}
+
+func handler(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	c := currentCache()
+	setCacheAge(w, c)
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, dataGz, etag := c.data, c.dataGz, c.dataETag
+	if f := pageFilterFrom(req); !f.empty() {
+		v, err := c.variant(f)
+		if err != nil {
+			errorln(err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		data, dataGz, etag = v.data, v.dataGz, v.etag
+	}
+
+	setCacheControl(w)
+	if notModified(w, req, etag, c.updated) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeCompressed(w, req, data, dataGz)
+}
+
+func apiBuilds(w http.ResponseWriter, req *http.Request) {
+	c := currentCache()
+	setCacheAge(w, c)
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	setCacheControl(w)
+	if notModified(w, req, c.jsonETag, c.updated) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeCompressed(w, req, c.json, c.jsonGz)
+}
+
+// cachedBuild returns the cached latest build for the given build type on
+// the given server. With multiple branches, the first configured branch
+// that has a build wins.
+func cachedBuild(srv int, buildTypeID string) (build, bool) {
+	c := currentCache()
+	if c == nil {
+		return build{}, false
+	}
+
+	for _, p := range c.projects {
+		for _, bt := range p.Builds {
+			if bt.Server == srv && bt.ID == buildTypeID && !bt.Pending {
+				return bt.Build, true
+			}
+		}
+	}
+	return build{}, false
+}
+
+// listedBuildType returns true if the build type is on the page, whether
+// or not it has a matching build. Like listedBuild, it guards endpoints
+// that query TeamCity with our credentials.
+func listedBuildType(srv int, buildTypeID string) bool {
+	c := currentCache()
+	if c == nil {
+		return false
+	}
+
+	for _, r := range c.results {
+		if r.bt.Server == srv && r.bt.ID == buildTypeID {
+			return true
+		}
+	}
+	for _, p := range c.projects {
+		for _, bt := range p.Builds {
+			if bt.Server == srv && bt.ID == buildTypeID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listedBuild returns true if the build is on the page, as the latest or an
+// earlier build of a build type. Endpoints that fetch from TeamCity with
+// our credentials only serve such builds, so that they don't expose builds
+// outside -project, -include and -exclude.
+func listedBuild(srv, id int) bool {
+	c := currentCache()
+	if c == nil {
+		return false
+	}
+
+	for _, p := range c.projects {
+		for _, bt := range p.Builds {
+			if bt.Server != srv {
+				continue
+			}
+			if !bt.Pending && bt.Build.ID == id || bt.LastFailure != nil && bt.LastFailure.ID == id {
+				return true
+			}
+			for _, h := range bt.Build.History {
+				if h.ID == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// serverParam returns the server selected by the "server" query parameter,
+// defaulting to the first one.
+func serverParam(req *http.Request) (int, bool) {
+	v := req.URL.Query().Get("server")
+	if v == "" {
+		return 0, true
+	}
+	srv, err := strconv.Atoi(v)
+	if err != nil || srv < 0 || srv >= len(servers) {
+		return 0, false
+	}
+	return srv, true
+}
+
+// serverQuery returns the query string selecting the given server in links
+// to our own handlers, which is empty for the first one.
+func serverQuery(srv int) string {
+	if srv == 0 {
+		return ""
+	}
+	return fmt.Sprintf("?server=%d", srv)
+}
+
+// setCacheAge sets the X-Cache-Age header to the number of seconds since
+// the last refresh, or "never".
+func setCacheAge(w http.ResponseWriter, c *cacheEntry) {
+	if c == nil {
+		w.Header().Set("X-Cache-Age", "never")
+		return
+	}
+	w.Header().Set("X-Cache-Age", strconv.Itoa(int(time.Since(c.updated).Seconds())))
+}
+
+// setCacheControl lets clients cache the response until the next scheduled
+// refresh, but no longer than maxPageAge. Pages behind -page-auth may only
+// be cached by the browser, not by shared caches that could serve them to
+// others.
+func setCacheControl(w http.ResponseWriter) {
+	if noCacheHdrs {
+		return
+	}
+	next := nextRefresh.Load()
+	if next == 0 {
+		return
+	}
+	secs := int(time.Until(time.Unix(0, next)).Seconds())
+	if secs < 0 {
+		secs = 0
+	} else if secs > int(maxPageAge.Seconds()) {
+		secs = int(maxPageAge.Seconds())
+	}
+	scope := "public"
+	if pageAuth != "" {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, secs))
+}
+
+// notModified sets the ETag and Last-Modified headers. If the request is a
+// conditional GET matching them it writes a 304 response and returns true.
+func notModified(w http.ResponseWriter, req *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimSpace(t); t == etag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(ims) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagFor returns a weak ETag for the given data. It's weak since the same
+// tag is used for the compressed and uncompressed variants.
+func etagFor(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// writeCompressed writes the gzipped variant of a cached response if the
+// client accepts it, otherwise the plain one.
+func writeCompressed(w http.ResponseWriter, req *http.Request, plain, gz []byte) {
+	w.Header().Set("Vary", "Accept-Encoding")
+	if gz != nil && acceptsGzip(req) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz)
+		return
+	}
+	w.Write(plain)
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if i := strings.IndexByte(enc, ';'); i >= 0 {
+			enc = enc[:i]
+		}
+		if strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBytes(bs []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// healthz reports that the process is up.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether the cache has been populated.
+func readyz(w http.ResponseWriter, _ *http.Request) {
+	if currentCache() == nil {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// refresh reloads everything and responds once done, or with a path like
+// /refresh/{name} queues a refresh of the builds of the named project or
+// build type. Requests made while everything is being reloaded share the
+// result of that refresh. Other requests closer together than
+// -min-refresh-interval are rejected.
+func refresh(w http.ResponseWriter, req *http.Request) {
+	target := strings.Trim(strings.TrimPrefix(req.URL.Path, "/refresh/"), "/")
+
+	// Joining a full refresh that is already running costs nothing
+	joining := target == "" && fullRefreshRunning.Load()
+	if !joining && minRefreshInterval > 0 {
+		now := time.Now()
+		last := lastManualRefresh.Load()
+		next := time.Unix(0, last).Add(minRefreshInterval)
+		if now.Before(next) || !lastManualRefresh.CompareAndSwap(last, now.UnixNano()) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())+1))
+			http.Error(w, "Refreshed too recently, retry later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if target != "" {
+		// Queued rather than joined, as the running refresh may already
+		// have passed the target.
+		requestRefresh(target)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := runRefresh(appCtx, nil); err != nil {
+		http.Error(w, "Refresh failed", http.StatusBadGateway)
+	}
+}
+
+var (
+	// Targets of pending partial refreshes. A full refresh is pending when
+	// pendingFull is set, which supersedes any targets.
+	pendingTargets = make(map[string]bool)
+	pendingFull    bool
+	pendingMut     sync.Mutex
+)
+
+// requestRefresh queues a refresh of the given project or build type, or
+// of everything if target is empty.
+func requestRefresh(target string) {
+	pendingMut.Lock()
+	if target == "" {
+		pendingFull = true
+	} else {
+		pendingTargets[target] = true
+	}
+	pendingMut.Unlock()
+
+	select {
+	case refreshRequests <- struct{}{}:
+	default:
+	}
+}
+
+// takePending returns and clears the pending refresh targets, and whether
+// a full refresh is pending.
+func takePending() ([]string, bool) {
+	pendingMut.Lock()
+	defer pendingMut.Unlock()
+
+	var targets []string
+	for t := range pendingTargets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	full := pendingFull
+	pendingFull = false
+	pendingTargets = make(map[string]bool)
+	return targets, full
+}
+
+func refreshLoop(ctx context.Context) {
+	// The ticker drops ticks while a refresh is running and the request
+	// channel holds at most one pending request, so concurrent triggers
+	// collapse into a single refresh.
+	var tick <-chan time.Time
+	if maxCacheTime > 0 {
+		t := time.NewTicker(maxCacheTime)
+		defer t.Stop()
+		tick = t.C
+		nextRefresh.Store(time.Now().Add(maxCacheTime).UnixNano())
+	}
+
+	for {
+		var targets []string
+		select {
+		case <-refreshRequests:
+			var full bool
+			targets, full = takePending()
+			if full {
+				targets = nil
+			} else if len(targets) == 0 {
+				// Already handled by a full refresh
+				continue
+			}
+		case now := <-tick:
+			nextRefresh.Store(now.Add(maxCacheTime).UnixNano())
+			takePending()
+		case <-ctx.Done():
+			return
+		}
+		runRefresh(ctx, targets)
+	}
+}
+
+var (
+	// refreshGroup coalesces concurrent refreshes of the same targets into
+	// one, whose result they all share.
+	refreshGroup singleflight.Group
+
+	// refreshMut serializes refreshes, so that a partial refresh never
+	// replaces a newer full one.
+	refreshMut sync.Mutex
+)
+
+// runRefresh refreshes the given projects or build types, or everything,
+// joining a refresh of the same targets that is already in flight.
+func runRefresh(ctx context.Context, targets []string) error {
+	_, err, _ := refreshGroup.Do(strings.Join(targets, "\x00"), func() (interface{}, error) {
+		refreshMut.Lock()
+		defer refreshMut.Unlock()
+		return nil, refreshCache(ctx, targets)
+	})
+	return err
+}
+
+// refreshCache reloads the builds of the given projects or build types and
+// updates the cache. With no targets, or before the cache is populated,
+// everything is reloaded. Errors are logged and returned.
+func refreshCache(ctx context.Context, targets []string) (err error) {
+	t0 := time.Now()
+	defer func() {
+		d := time.Since(t0)
+		metricRefreshDuration.Observe(d.Seconds())
+		setLastRefresh(refreshOutcome{started: t0, duration: d, err: err})
+		logEvent(slog.LevelInfo, "refresh", fmt.Sprint("Done in ", d), "duration_ms", d.Milliseconds(), "targets", targets, "error", errString(err))
+	}()
+
+	metricRefreshes.Inc()
+	if maxCacheTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxCacheTime)
+		defer cancel()
+	}
+
+	var results []buildResult
+	if c := currentCache(); c != nil && c.results != nil && len(targets) > 0 {
+		infoln("Refresh", strings.Join(targets, ", "))
+		results = reloadResults(ctx, c.results, targets)
+	} else {
+		infoln("Refresh cache")
+		fullRefreshRunning.Store(true)
+		defer fullRefreshRunning.Store(false)
+		targets = nil
+		results, err = loadResults(ctx)
+	}
+	if err == nil && anyBreakerOpen() && currentCache() != nil {
+		// The results are missing whatever the breaker stopped
+		err = errBreakerOpen
+	}
+	if err == nil {
+		err = storeCache(ctx, results)
+	}
+	if err != nil {
+		// Keep serving the last good render
+		metricRefreshFailures.Inc()
+		errorln(err)
+	}
+	return err
+}
+
+// storeCache renders the results and replaces the cache.
+func storeCache(ctx context.Context, results []buildResult) error {
+	projs, loadErrs := groupProjects(ctx, results)
+	if mirrorDir != "" {
+		linkMirrored(projs)
+	}
+	if s3Bucket != "" && s3PublicURL != "" {
+		linkUploaded(projs)
+	}
+	now := time.Now()
+	bs, js, err := getTpl(projs, loadErrs, now)
+	if err != nil {
+		return err
+	}
+
+	// Compress once here so serving is free. On failure we serve
+	// uncompressed only.
+	bsGz, gzErr := gzipBytes(bs)
+	if gzErr != nil {
+		errorln("Compressing page:", gzErr)
+	}
+	jsGz, gzErr := gzipBytes(js)
+	if gzErr != nil {
+		errorln("Compressing JSON:", gzErr)
+	}
+
+	c := &cacheEntry{
+		data:     bs,
+		dataGz:   bsGz,
+		dataETag: etagFor(bs),
+		json:     js,
+		jsonGz:   jsGz,
+		jsonETag: etagFor(js),
+		projects: projs,
+		loadErrs: loadErrs,
+		results:  results,
+		updated:  now,
+	}
+	cache.Store(c)
+
+	if mirrorDir != "" || s3Bucket != "" {
+		copyArtifacts(projs)
+	}
+	if slackWebhook != "" {
+		notifySlack(projs)
+	}
+
+	if cacheFile != "" {
+		if err := saveCacheFile(cacheFile, c); err != nil {
+			warnln(err)
+		}
+	}
+	return nil
+}
+
+// artifactCopyRunning is set while artifacts are being copied in the
+// background.
+var artifactCopyRunning atomic.Bool
+
+// copyArtifacts mirrors the artifacts of the projects and uploads them to
+// S3 in the background, so that refreshes don't wait for large transfers.
+// The copies are linked on the next render. If a copy is already running
+// this one is skipped and the next refresh catches up.
+func copyArtifacts(projs []project) {
+	if !artifactCopyRunning.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer artifactCopyRunning.Store(false)
+		if mirrorDir != "" {
+			mirrorArtifacts(appCtx, projs)
+		}
+		if s3Bucket != "" {
+			syncS3(appCtx, projs)
+		}
+	}()
+}
+
+// getTpl returns the rendered HTML page and the JSON representation of the
+// given projects, as of the given refresh time.
+func getTpl(projs []project, loadErrs []loadError, updated time.Time) ([]byte, []byte, error) {
+	data := map[string]interface{}{
+		"Branch":      branch,
+		"Branches":    branches,
+		"MultiBranch": len(branches) > 1,
+		"Base":        tc.base,
+		"MultiServer": len(servers) > 1,
+		"Status":      buildStatus,
+		"PinnedOnly":  pinnedOnly,
+		"Tag":         buildTag,
+		"Projects":    projs,
+		"Errors":      loadErrs,
+		"AuthFailed":  authFailed(loadErrs),
+		"Updated":     updated.In(displayLoc),
+		"CDN":         useCDN,
+		"Theme":       theme,
+		"Collapsed":   collapsed,
+		"AutoRefresh": int(autoRefresh.Seconds()),
+		"Footer":      footerHTML(footer),
+		"Logo":        logoURL(),
+	}
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, data); err != nil {
+		return nil, nil, errors.Wrap(err, "execute template")
+	}
+
+	js, err := json.Marshal(apiData{
+		Base:     tc.base,
+		Branches: branches,
+		Projects: projs,
+		Errors:   loadErrs,
+		Updated:  updated.In(displayLoc),
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "marshal JSON")
+	}
+
+	return buf.Bytes(), js, nil
+}
 
-	go refreshLoop()
-	refreshRequests <- struct{}{}
+// apiData is the document served on /api/builds. Unlike the template data
+// it leaves out page settings, so that those can change without breaking
+// API clients.
+type apiData struct {
+	Base     string
+	Branches []string
+	Projects []project
+	Errors   []loadError
+	Updated  time.Time
+}
 
-	http.ListenAndServe(listen, nil)
+// A loadError describes a build type that could not be loaded.
+type loadError struct {
+	Project   string
+	BuildType string
+	Branch    string
+	Error     string
+	Auth      bool // TeamCity rejected the credentials
 }
 
-var (
-	refreshRequests = make(chan struct{}, 1)
-	cacheData       []byte
-	cacheMut        sync.Mutex
-)
+// newLoadError returns the loadError describing the failed result.
+func newLoadError(r buildResult) loadError {
+	return loadError{
+		Project:   r.bt.ProjectName,
+		BuildType: r.bt.Name,
+		Branch:    r.branch,
+		Error:     r.err.Error(),
+		Auth:      isAuthError(r.err),
+	}
+}
 
-func handler(w http.ResponseWriter, req *http.Request) {
-	cacheMut.Lock()
-	bs := cacheData
-	cacheMut.Unlock()
+// authFailed returns true if any of the errors is an authentication
+// failure.
+func authFailed(loadErrs []loadError) bool {
+	for _, e := range loadErrs {
+		if e.Auth {
+			return true
+		}
+	}
+	return false
+}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write(bs)
+// A buildResult is the outcome of looking up the latest build of a build
+// type on a branch. On success bt has the build filled in.
+type buildResult struct {
+	bt     buildType
+	branch string
+	err    error
 }
 
-func refresh(_ http.ResponseWriter, _ *http.Request) {
-	select {
-	case refreshRequests <- struct{}{}:
-	default:
+// loadResults looks up the latest build of every build type and branch, on
+// all servers.
+func loadResults(ctx context.Context) ([]buildResult, error) {
+	var types []buildType
+	var authFailures []buildResult
+	for srv := range servers {
+		bts, err := getBuildTypes(ctx, srv)
+		if isAuthError(err) {
+			// Show the rejected credentials on the page rather than
+			// failing the refresh
+			errorln(server(srv).Name()+":", err)
+			authFailures = append(authFailures, buildResult{
+				bt:  buildType{Server: srv, ProjectName: server(srv).Name(), Name: "all build types"},
+				err: err,
+			})
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "loadResults")
+		}
+		types = append(types, bts...)
+	}
+	types = filterBuildTypes(types)
+
+	sort.Slice(types, func(a, b int) bool {
+		if types[a].Server != types[b].Server {
+			return types[a].Server < types[b].Server
+		}
+		if types[a].ProjectName != types[b].ProjectName {
+			return naturalLess(types[a].ProjectName, types[b].ProjectName)
+		}
+		return naturalLess(types[a].Name, types[b].Name)
+	})
+
+	var results []buildResult
+	for _, bt := range types {
+		for _, br := range branches {
+			results = append(results, buildResult{bt: bt, branch: br})
+		}
 	}
+	loadBuilds(ctx, results, nil)
+	return append(authFailures, results...), nil
 }
 
-func refreshLoop() {
-	for _ = range refreshRequests {
-		refreshCache()
+// reloadResults returns a copy of the previous results where the build
+// types matching any of the targets, by project name or ID or by build type
+// ID, have been looked up again.
+func reloadResults(ctx context.Context, prev []buildResult, targets []string) []buildResult {
+	results := make([]buildResult, len(prev))
+	copy(results, prev)
+
+	var idxs []int
+	for i, r := range results {
+		for _, t := range targets {
+			if r.bt.ID != "" && (t == r.bt.ProjectName || t == r.bt.ProjectID || t == r.bt.ID) {
+				idxs = append(idxs, i)
+				break
+			}
+		}
 	}
+	loadBuilds(ctx, results, idxs)
+	return results
 }
 
-func refreshCache() {
-	t0 := time.Now()
-	defer func() {
-		log.Println("Done in", time.Since(t0))
-	}()
+// loadBuilds fills in the build of the results at the given indexes, or of
+// all results if idxs is nil, using a bounded pool of workers.
+func loadBuilds(ctx context.Context, results []buildResult, idxs []int) {
+	if idxs == nil {
+		idxs = make([]int, len(results))
+		for i := range idxs {
+			idxs[i] = i
+		}
+	}
 
-	cacheMut.Lock()
-	defer cacheMut.Unlock()
+	// Once a server rejects our credentials there is no point in asking
+	// it about the remaining build types.
+	authErrs := make([]error, len(servers))
+	var authMut sync.Mutex
 
-	log.Println("Refresh cache")
-	bs, err := getTpl()
-	if err != nil {
-		log.Println(err)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				r := &results[i]
+				authMut.Lock()
+				authErr := authErrs[r.bt.Server]
+				authMut.Unlock()
+				if authErr != nil {
+					r.err = authErr
+					continue
+				}
+
+				r.bt, r.err = getBuildType(ctx, r.bt, r.branch)
+				switch {
+				case r.err == nil, r.err == errNoBuild:
+				case isAuthError(r.err):
+					authMut.Lock()
+					if authErrs[r.bt.Server] == nil {
+						authErrs[r.bt.Server] = r.err
+						errorln(server(r.bt.Server).Name()+":", r.err)
+					}
+					authMut.Unlock()
+				default:
+					warnln(fmt.Sprintf("Loading %s / %s:", r.bt.ProjectName, r.bt.Name), r.err)
+				}
+			}
+		}()
+	}
+	for _, i := range idxs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// groupProjects returns the projects with their latest builds, and the
+// build types that failed to load.
+func groupProjects(ctx context.Context, results []buildResult) ([]project, []loadError) {
+	var projs []project
+	var loadErrs []loadError
+	projIdxs := make(map[string]int)
+
+	for _, r := range results {
+		if r.bt.ID == "" {
+			// The server's build types could not be listed
+			loadErrs = append(loadErrs, newLoadError(r))
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%s", r.bt.Server, r.bt.ProjectName)
+		idx, ok := projIdxs[key]
+		if !ok {
+			idx = len(projs)
+			projIdxs[key] = idx
+			projs = append(projs, project{ID: r.bt.ProjectID, Name: r.bt.ProjectName, Server: r.bt.Server})
+		}
+
+		switch r.err {
+		case nil:
+			if tooOld(r.bt.Build) {
+				continue
+			}
+			projs[idx].Builds = append(projs[idx].Builds, r.bt)
+			projs[idx].TotalSize += r.bt.Build.TotalSize
+		case errNoBuild:
+			// Nothing to show, not an error
+		default:
+			loadErrs = append(loadErrs, newLoadError(r))
+		}
+	}
+
+	if !flat {
+		projs = nestServerProjects(ctx, projs)
+	}
+
+	return projs, loadErrs
+}
+
+// nestServerProjects nests the projects of each server according to its
+// project hierarchy. Projects are ordered by server, as are the build
+// results they come from.
+func nestServerProjects(ctx context.Context, projs []project) []project {
+	var res []project
+	for len(projs) > 0 {
+		srv := projs[0].Server
+		n := 1
+		for n < len(projs) && projs[n].Server == srv {
+			n++
+		}
+
+		infos, err := getProjectInfos(ctx, srv)
+		if err != nil {
+			warnln("Showing flat project list:", err)
+			res = append(res, projs[:n]...)
+		} else {
+			nested := nestProjects(projs[:n], infos)
+			for i := range nested {
+				nested[i].Server = srv
+			}
+			res = append(res, nested...)
+		}
+		projs = projs[n:]
 	}
+	return res
+}
 
-	cacheData = bs
+// tooOld returns true if the build finished longer than -max-age ago.
+func tooOld(b build) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	t := b.FinishTime()
+	return !t.IsZero() && t.Before(time.Now().Add(-maxAge))
 }
 
-func getTpl() ([]byte, error) {
-	types, err := getBuildTypes()
+// checkBaseURL returns an error unless s is an absolute HTTP(S) URL.
+func checkBaseURL(s string) error {
+	u, err := url.Parse(s)
 	if err != nil {
-		return nil, errors.Wrap(err, "getTpl")
+		return err
 	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
 
-	sort.Slice(types, func(a, b int) bool {
-		if types[a].ProjectName != types[b].ProjectName {
-			return types[a].ProjectName < types[b].ProjectName
+// logConfig logs the effective configuration, leaving out secrets.
+func logConfig() {
+	var bases []string
+	for _, c := range servers {
+		bases = append(bases, c.base)
+	}
+	attrs := []any{
+		"base", bases,
+		"listen", listen,
+		"branches", branches,
+		"project", projectName,
+		"status", buildStatus,
+		"include_running", withRunning,
+		"pinned_only", pinnedOnly,
+		"tag", buildTag,
+		"cache", maxCacheTime.String(),
+		"concurrency", concurrency,
+		"warm", warm,
+		"breaker_failures", breakerFailures,
+		"breaker_cooldown", breakerCooldown.String(),
+		"user_agent", userAgent,
+		"auth_mode", authMode,
+		"auth", auth != "",
+		"token", token != "",
+		"page_auth", pageAuth != "",
+		"webhook_secret", webhookSecret != "",
+		"tls", tlsCert != "",
+		"mirror_dir", mirrorDir,
+		"s3_bucket", s3Bucket,
+		"slack", slackWebhook != "",
+		"digest_interval", digestInterval.String(),
+	}
+
+	msg := "tcbuilds " + Version
+	for i := 0; i < len(attrs); i += 2 {
+		msg += fmt.Sprintf(" %s=%v", attrs[i], attrs[i+1])
+	}
+	logEvent(slog.LevelInfo, "config", msg, append([]any{"version", Version}, attrs...)...)
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var res []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
 		}
-		return types[a].Name < types[b].Name
-	})
+	}
+	return res
+}
 
-	var projs []project
-	projIdxs := make(map[string]int)
+// splitPerServer splits a comma separated flag value with an entry per
+// server, where entries may be empty.
+func splitPerServer(s string) []string {
+	if s == "" {
+		return nil
+	}
+	res := strings.Split(s, ",")
+	for i := range res {
+		res[i] = strings.TrimSpace(res[i])
+	}
+	return res
+}
+
+// listEntry returns the entry for server i from a per server list. A
+// single entry applies to all servers.
+func listEntry(list []string, i int) string {
+	switch len(list) {
+	case 0:
+		return ""
+	case 1:
+		return list[0]
+	default:
+		return list[i]
+	}
+}
+
+// filterBuildTypes applies the -include and -exclude patterns. Exclude wins
+// when both match.
+func filterBuildTypes(types []buildType) []buildType {
+	matches := func(re *regexp.Regexp, bt buildType) bool {
+		return re.MatchString(bt.Name) || re.MatchString(bt.ProjectName)
+	}
 
+	var res []buildType
 	for _, bt := range types {
-		idx, ok := projIdxs[bt.ProjectName]
-		if !ok {
-			idx = len(projs)
-			projIdxs[bt.ProjectName] = idx
-			projs = append(projs, project{Name: bt.ProjectName})
+		if includeRe != nil && !matches(includeRe, bt) {
+			continue
+		}
+		if excludeRe != nil && matches(excludeRe, bt) {
+			continue
 		}
+		res = append(res, bt)
+	}
+	return res
+}
+
+// getBuildType returns a copy of the build type with the latest build on
+// the given branch and its files filled in.
+func getBuildType(ctx context.Context, bt buildType, branch string) (buildType, error) {
+	build, err := getLatestBuild(ctx, bt.Server, bt.ID, branch)
+	if err == errNoBuild && showEmpty {
+		return pendingBuildType(ctx, bt, branch), nil
+	}
+	if err != nil {
+		return bt, err
+	}
 
-		build, err := getLatestBuild(bt.ID, branch)
+	if maxChanges > 0 {
+		build.Changes, build.MoreChanges, err = buildChanges(ctx, bt.Server, build.ID)
 		if err != nil {
-			continue
+			warnln(err)
 		}
+	}
 
-		files, err := getFiles(build.ID)
+	if showTests {
+		build.Tests, err = buildTests(ctx, bt.Server, build)
 		if err != nil {
-			continue
+			warnln(err)
 		}
+	}
 
+	key := fmt.Sprintf("%d/%s@%s", bt.Server, bt.ID, branch)
+	if files, ok := lastFiles(key, build); ok {
 		build.Files = files
+		build.TotalSize = totalSize(files)
 		bt.Build = build
-		projs[idx].Builds = append(projs[idx].Builds, bt)
+		return bt, nil
 	}
 
-	data := map[string]interface{}{
-		"Branch":   branch,
-		"Base":     base,
-		"Projects": projs,
+	files, err := getFiles(ctx, bt.Server, build.ID)
+	if err != nil {
+		return bt, err
 	}
-	buf := new(bytes.Buffer)
-	if err := tpl.Execute(buf, data); err != nil {
-		return nil, errors.Wrap(err, "execute template")
+	files = filterFiles(files)
+
+	if checksums {
+		for i := range files {
+			if files[i].Content.HRef == "" {
+				// Directory
+				continue
+			}
+			sum, err := getChecksum(ctx, bt.Server, build.ID, files[i])
+			if err != nil {
+				warnln(err)
+				continue
+			}
+			files[i].SHA256 = sum
+		}
 	}
 
-	return buf.Bytes(), nil
+	setLastFiles(key, build, files)
+
+	build.Files = files
+	build.TotalSize = totalSize(files)
+	bt.Build = build
+	return bt, nil
+}
+
+// pendingBuildType marks the build type as having no matching build on the
+// branch, filling in its latest build regardless of status, pinning and
+// tags if those filters are what excluded it.
+func pendingBuildType(ctx context.Context, bt buildType, branch string) buildType {
+	bt.Pending = true
+	bt.Build.BranchName = branch
+	if !server(bt.Server).filtersBuilds() {
+		return bt
+	}
+	b, err := getLatestAnyBuild(ctx, bt.Server, bt.ID, branch)
+	switch err {
+	case nil:
+		bt.LastFailure = &b
+	case errNoBuild:
+	default:
+		warnln(err)
+	}
+	return bt
+}
+
+// filterFiles drops the artifacts hidden by -artifact-exclude and
+// -hide-empty-artifacts.
+func filterFiles(files []file) []file {
+	var res []file
+	for _, f := range files {
+		if !hiddenFile(f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// hiddenFile returns true if the artifact is hidden by -artifact-exclude or
+// -hide-empty-artifacts.
+func hiddenFile(f file) bool {
+	if hideEmpty && f.Size == 0 && f.Content.HRef != "" {
+		return true
+	}
+	return artifactExcludeRe != nil && artifactExcludeRe.MatchString(f.Name)
+}
+
+func totalSize(files []file) int {
+	total := 0
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
+
+var (
+	// The files of the last seen build per build type and branch, so that
+	// we only list artifacts when there is a new build.
+	filesCache    = make(map[string]cachedFiles)
+	filesCacheMut sync.Mutex
+)
+
+type cachedFiles struct {
+	buildID int
+	files   []file
+}
+
+func lastFiles(key string, b build) ([]file, bool) {
+	filesCacheMut.Lock()
+	defer filesCacheMut.Unlock()
+	c, ok := filesCache[key]
+	if !ok || c.buildID != b.ID {
+		return nil, false
+	}
+	return c.files, true
+}
+
+func setLastFiles(key string, b build, files []file) {
+	if b.State != "finished" {
+		// Running builds may still gain artifacts
+		return
+	}
+	filesCacheMut.Lock()
+	filesCache[key] = cachedFiles{buildID: b.ID, files: files}
+	filesCacheMut.Unlock()
 }
 
 type project struct {
+	ID     string
 	Name   string
 	Builds []buildType
+	Server int // index into servers
+
+	// Sum of the artifact sizes of the builds
+	TotalSize int
+
+	// Set when showing the project hierarchy
+	ShortName      string
+	Depth          int
+	subtreeVisible bool
+}
+
+// Title returns the name to display for the project.
+func (p project) Title() string {
+	if p.ShortName != "" {
+		return p.ShortName
+	}
+	return p.Name
+}
+
+// Indent returns the left margin for the project, by depth.
+func (p project) Indent() string {
+	return fmt.Sprintf("%dem", 2*p.Depth)
 }
 
 func (p project) NameID() string {
-	return strings.Replace(p.Name, " ", "-", -1)
+	id := strings.Replace(p.Name, " ", "-", -1)
+	if p.Server > 0 {
+		// Keep IDs unique when servers have projects of the same name
+		id = fmt.Sprintf("%s-%d", id, p.Server)
+	}
+	return id
+}
+
+// ServerName returns the name of the server the project is on.
+func (p project) ServerName() string {
+	return server(p.Server).Name()
+}
+
+func (p project) TotalSizeStr() string {
+	return sizeStr(p.TotalSize)
 }
 
 func (p project) TotalFiles() int {
@@ -171,9 +1750,24 @@ func (p project) TotalFiles() int {
 	return count
 }
 
+// Visible returns true if any of the project's builds should be shown, or
+// if it has a subproject that is visible.
+func (p project) Visible() bool {
+	if p.subtreeVisible {
+		return true
+	}
+	for _, b := range p.Builds {
+		if b.Visible() {
+			return true
+		}
+	}
+	return false
+}
+
 type buildTypeResponse struct {
 	Count      int
 	HRef       string
+	NextHRef   string
 	BuildTypes []buildType `json:"buildType"`
 }
 
@@ -184,8 +1778,21 @@ type buildType struct {
 	ProjectID   string
 	HRef        string
 	WebURL      string
+	Server      int // index into servers, filled in later
 
 	Build build // filled in later
+
+	// Set with -show-empty when there is no matching build, along with
+	// the latest build of any status, if there is one.
+	Pending     bool
+	LastFailure *build
+}
+
+// Visible returns true if the build type should be shown. Builds without
+// artifacts are hidden, unless they are running or unsuccessful, as
+// those rarely have artifacts to show.
+func (bt buildType) Visible() bool {
+	return bt.Pending || len(bt.Build.Files) > 0 || bt.Build.State != "finished" || bt.Build.Status != "SUCCESS"
 }
 
 type buildResponse struct {
@@ -200,6 +1807,7 @@ type build struct {
 	BuildTypeID   string
 	Number        string
 	State         string
+	Status        string
 	BranchName    string
 	DefaultBranch bool
 	HRef          string
@@ -211,126 +1819,294 @@ type build struct {
 	Agent         struct {
 		Name string
 	}
+	Revisions struct {
+		Revision []struct {
+			Version string
+		}
+	}
+	Triggered struct {
+		Type    string
+		Details string
+		User    struct {
+			Username string
+			Name     string
+		}
+		BuildType struct { // set when triggered by another build
+			Name string
+		}
+	}
+	PercentageComplete int // set while running
+	Pinned             bool
+	Tags               struct {
+		Tag []struct {
+			Name string
+		}
+	}
+
+	Server    int    // index into servers, filled in later
+	Files     []file // filled in later
+	TotalSize int    // sum of the file sizes, filled in later
 
-	Files []file // filled in later
+	History     []build     // earlier builds, newest first, if -history > 1
+	Changes     []change    // filled in later, if enabled
+	MoreChanges bool        // set when there are changes beyond those listed
+	Tests       *testCounts // filled in later, if enabled and present
 }
 
-func (b build) DateStr() string {
-	d, _ := time.Parse("20060102T150405-0700", b.FinishDate)
-	return d.UTC().Format("2006-01-02 15:04:05 MST")
+func (b build) TotalSizeStr() string {
+	return sizeStr(b.TotalSize)
 }
 
-type artifactResponse struct {
-	Count int
-	Files []file `json:"file"`
+// StatusClass returns the Bootstrap contextual class matching the build
+// state and status.
+func (b build) StatusClass() string {
+	switch {
+	case b.State != "finished":
+		return "warning"
+	case b.Status == "SUCCESS":
+		return "success"
+	default:
+		return "danger"
+	}
 }
 
-type file struct {
-	Name             string
-	Size             int
-	ModificationTime string
-	HRef             string
-	Content          struct {
-		HRef string
+func (b build) DownloadURL(f file) string {
+	return downloadURL(b.Server, b.ID, f)
+}
+
+// ZipURL returns the link to download all artifacts as a zip archive.
+func (b build) ZipURL() string {
+	return fmt.Sprintf("zip/%d%s", b.ID, serverQuery(b.Server))
+}
+
+// ShownFiles returns the files to list on the page, at most -max-artifacts.
+func (b build) ShownFiles() []file {
+	if maxArtifacts > 0 && len(b.Files) > maxArtifacts {
+		return b.Files[:maxArtifacts]
 	}
+	return b.Files
 }
 
-func (f file) SizeStr() string {
-	const (
-		_ = 1 << (10 * iota)
-		KiB
-		MiB
-	)
-	if f.Size >= MiB {
-		mib := float64(f.Size) / MiB
-		return fmt.Sprintf("%.02f MiB", mib)
+// FileGroups returns the files to list on the page, grouped by platform.
+func (b build) FileGroups() []fileGroup {
+	return groupFiles(b.ShownFiles())
+}
+
+// HiddenFiles returns the number of files not listed on the page.
+func (b build) HiddenFiles() int {
+	return len(b.Files) - len(b.ShownFiles())
+}
+
+// BrowseURL returns the link to the artifact browser for the build.
+func (b build) BrowseURL() string {
+	return fmt.Sprintf("artifacts/%d/%s", b.ID, serverQuery(b.Server))
+}
+
+// ArtifactsURL returns the TeamCity page listing all artifacts of the
+// build.
+func (b build) ArtifactsURL() string {
+	if strings.Contains(b.WebURL, "?") {
+		return b.WebURL + "&tab=artifacts"
 	}
-	kib := float64(f.Size) / KiB
-	return fmt.Sprintf("%.01f KiB", kib)
+	return b.WebURL + "?buildTab=artifacts"
 }
 
-func getBuildTypes() ([]buildType, error) {
-	extra := ""
-	if projectName != "" {
-		extra = "?locator=affectedProject:(id:" + projectName + ")"
+// Commit returns the VCS revision the build was made from, or an empty
+// string if unknown. With several VCS roots the first one is used.
+func (b build) Commit() string {
+	if len(b.Revisions.Revision) == 0 {
+		return ""
 	}
-	url := fmt.Sprintf("/app/rest/buildTypes%s", extra)
-	var res buildTypeResponse
-	if err := getJSON(url, &res); err != nil {
-		return nil, errors.Wrap(err, "get build types")
+	return b.Revisions.Revision[0].Version
+}
+
+// ShortCommit returns the commit abbreviated for display.
+func (b build) ShortCommit() string {
+	c := b.Commit()
+	if len(c) > 7 {
+		return c[:7]
 	}
-	return res.BuildTypes, nil
+	return c
 }
 
-func getLatestBuild(buildTypeID, branch string) (build, error) {
-	url := fmt.Sprintf("/app/rest/buildTypes/id:%s/builds?locator=branch:%s,state:finished,status:SUCCESS,count:1", buildTypeID, branch)
-	var res buildResponse
-	if err := getJSON(url, &res); err != nil {
-		return build{}, errors.Wrap(err, "get latest build")
+// CommitURL returns a link to the commit per -commit-url, or an empty
+// string.
+func (b build) CommitURL() string {
+	if commitURL == "" || b.Commit() == "" {
+		return ""
 	}
-	if len(res.Builds) != 1 {
-		return build{}, errors.New("no build found")
+	return fmt.Sprintf(commitURL, b.Commit())
+}
+
+// TagNames returns the names of the build's tags.
+func (b build) TagNames() []string {
+	var names []string
+	for _, t := range b.Tags.Tag {
+		names = append(names, t.Name)
 	}
+	return names
+}
 
-	// re-get the build for more info
+func (b build) DateStr() string {
+	return b.FinishTime().In(displayLoc).Format("2006-01-02 15:04:05 MST")
+}
 
-	var b build
-	if err := getJSON(res.Builds[0].HRef, &b); err != nil {
-		return build{}, errors.Wrap(err, "get latest build details")
-	}
+// QueuedStr returns when the build was queued, or an empty string if
+// unknown.
+func (b build) QueuedStr() string {
+	return tcTimeStr(b.QueuedDate)
+}
 
-	return b, nil
+// StartStr returns when the build started, or an empty string if unknown.
+func (b build) StartStr() string {
+	return tcTimeStr(b.StartDate)
 }
 
-func getFiles(buildID int) ([]file, error) {
-	url := fmt.Sprintf("/app/rest/builds/id:%d/artifacts/children", buildID)
-	var res artifactResponse
-	if err := getJSON(url, &res); err != nil {
-		return nil, errors.Wrap(err, "get files")
+func tcTimeStr(s string) string {
+	t, err := time.Parse(tcTimeFormat, s)
+	if err != nil {
+		return ""
 	}
-	return res.Files, nil
+	return t.In(displayLoc).Format("2006-01-02 15:04:05 MST")
 }
 
-func getJSON(url string, into interface{}) error {
-	authPart := ""
+// TriggeredBy describes what triggered the build, or returns an empty
+// string if unknown.
+func (b build) TriggeredBy() string {
+	t := b.Triggered
 	switch {
-	case strings.HasPrefix(url, "/guestAuth"):
-	case strings.HasPrefix(url, "/httpAuth"):
-	case auth != "":
-		authPart = "/httpAuth"
-	default:
-		authPart = "/guestAuth"
+	case t.User.Name != "":
+		return t.User.Name
+	case t.User.Username != "":
+		return t.User.Username
+	}
+	switch t.Type {
+	case "", "unknown":
+		return t.Details
+	case "vcs":
+		return "VCS change"
+	case "schedule":
+		return "schedule"
+	case "buildType":
+		if t.BuildType.Name != "" {
+			return "build of " + t.BuildType.Name
+		}
+		return "another build"
+	case "snapshotDependency":
+		return "snapshot dependency"
+	case "retry":
+		return "retry"
+	case "restApi":
+		return "REST API"
+	}
+	if t.Details != "" {
+		return t.Details
 	}
+	return t.Type
+}
+
+// DetailsURL returns the link to the page about the build.
+func (b build) DetailsURL() string {
+	return fmt.Sprintf("build/%d%s", b.ID, serverQuery(b.Server))
+}
 
-	req, err := http.NewRequest(http.MethodGet, base+authPart+url, nil)
+// RelativeStr returns how long ago the build finished, in coarse units, or
+// an empty string if the finish date is unknown. As the page is rendered at
+// refresh time, this is relative to the last refresh.
+func (b build) RelativeStr() string {
+	d, err := time.Parse(tcTimeFormat, b.FinishDate)
 	if err != nil {
-		return errors.Wrap(err, "create request")
+		return ""
 	}
+	return relativeTime(time.Since(d))
+}
 
-	req.Header.Set("Accept", "application/json")
-	if auth != "" {
-		fields := strings.Split(auth, ":")
-		if len(fields) == 2 {
-			req.SetBasicAuth(fields[0], fields[1])
+func relativeTime(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
 		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
 	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
 
-	log.Println(req.URL)
-
-	resp, err := http.DefaultClient.Do(req)
+// DurationStr returns the time the build took to run, or an empty string
+// if it hasn't finished.
+func (b build) DurationStr() string {
+	start, err := time.Parse(tcTimeFormat, b.StartDate)
 	if err != nil {
-		return errors.Wrap(err, "HTTP get")
+		return ""
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(resp.Status)
+	finish, err := time.Parse(tcTimeFormat, b.FinishDate)
+	if err != nil {
+		return ""
 	}
+	return finish.Sub(start).Round(time.Second).String()
+}
 
-	bs, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "HTTP read")
+// FinishTime returns the parsed finish date, or the zero time for builds
+// that haven't finished.
+func (b build) FinishTime() time.Time {
+	d, _ := time.Parse(tcTimeFormat, b.FinishDate)
+	return d
+}
+
+type artifactResponse struct {
+	Count int
+	Files []file `json:"file"`
+}
+
+type file struct {
+	Name             string
+	Size             int
+	ModificationTime string
+	HRef             string
+	Content          struct {
+		HRef string
+	}
+	Children struct { // set for directories
+		HRef string
 	}
 
-	return errors.Wrap(json.Unmarshal(bs, into), "JSON unmarshal")
+	SHA256 string // filled in later, if enabled
+	Mirror string // path under -mirror-dir, if mirrored
+	Public string // URL under -s3-public-url, if uploaded
+}
+
+func (f file) SizeStr() string {
+	return sizeStr(f.Size)
+}
+
+// sizeStr formats a size in bytes for humans, in the largest unit where
+// the value is at least one.
+func sizeStr(size int) string {
+	const (
+		_ = 1 << (10 * iota)
+		KiB
+		MiB
+		GiB
+		TiB
+	)
+	switch {
+	case size >= TiB:
+		return fmt.Sprintf("%.02f TiB", float64(size)/TiB)
+	case size >= GiB:
+		return fmt.Sprintf("%.02f GiB", float64(size)/GiB)
+	case size >= MiB:
+		return fmt.Sprintf("%.02f MiB", float64(size)/MiB)
+	case size >= KiB:
+		return fmt.Sprintf("%.01f KiB", float64(size)/KiB)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTestServer points tcbuilds at a fake TeamCity served by h, with the
+// default template and an empty cache, until the test is done.
+func useTestServer(t testing.TB, h http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	oldServers, oldTC, oldTpl, oldBranches := servers, tc, tpl, branches
+	t.Cleanup(func() {
+		servers, tc, tpl, branches = oldServers, oldTC, oldTpl, oldBranches
+		cache.Store((*cacheEntry)(nil))
+	})
+
+	tc = &Client{base: srv.URL, httpClient: srv.Client(), retryDelay: time.Millisecond}
+	servers = []*Client{tc}
+	tpl = template.Must(template.New("template.html").Funcs(templateFuncs).Parse(defaultTemplate))
+	branches = []string{"master"}
+	cache.Store((*cacheEntry)(nil))
+	return srv
+}
+
+func TestConcurrentRefreshesCrawlOnce(t *testing.T) {
+	var crawls atomic.Int32
+	release := make(chan struct{})
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/guestAuth/app/rest/buildTypes" {
+			crawls.Add(1)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":0}`))
+	}))
+
+	const n = 50
+	var started, done sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			started.Done()
+			errs <- runRefresh(context.Background(), nil)
+		}()
+	}
+	started.Wait()
+	time.Sleep(100 * time.Millisecond) // let every caller join
+	close(release)
+	done.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := crawls.Load(); c != 1 {
+		t.Errorf("got %d crawls, expected 1", c)
+	}
+	if currentCache() == nil {
+		t.Error("cache not populated")
+	}
+}
+
+func TestFailedRenderKeepsCache(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":0}`))
+	}))
+
+	if err := refreshCache(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	good := currentCache()
+	if good == nil || len(good.data) == 0 {
+		t.Fatal("first refresh did not render a page")
+	}
+
+	// Executing a missing template makes getTpl fail
+	tpl = template.Must(template.New("broken").Parse(`{{template "missing"}}`))
+	if err := refreshCache(context.Background(), nil); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+	if c := currentCache(); c != good || !bytes.Equal(c.data, good.data) {
+		t.Error("failed refresh replaced the cached page")
+	}
+}
+
+// BenchmarkServeDuringRefresh serves the page while a refresh is stuck
+// waiting for TeamCity. Reads blocking on the refresh would hang here.
+func BenchmarkServeDuringRefresh(b *testing.B) {
+	var stuck atomic.Bool
+	release := make(chan struct{})
+	useTestServer(b, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if stuck.Load() && req.URL.Path == "/guestAuth/app/rest/buildTypes" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":0}`))
+	}))
+	if err := refreshCache(context.Background(), nil); err != nil {
+		b.Fatal(err)
+	}
+
+	stuck.Store(true)
+	refreshed := make(chan struct{})
+	go func() {
+		runRefresh(context.Background(), nil)
+		close(refreshed)
+	}()
+	for !fullRefreshRunning.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusOK {
+				b.Errorf("got status %d", rec.Code)
+			}
+		}
+	})
+	b.StopTimer()
+
+	if !fullRefreshRunning.Load() {
+		b.Error("refresh finished during the benchmark")
+	}
+	close(release)
+	<-refreshed
+}
+
+func TestSizeStr(t *testing.T) {
+	cases := []struct {
+		size int
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1<<20 - 1, "1024.0 KiB"},
+		{1 << 20, "1.00 MiB"},
+		{5<<20 + 1<<19, "5.50 MiB"},
+		{1<<30 - 1, "1024.00 MiB"},
+		{1 << 30, "1.00 GiB"},
+		{3 << 30, "3.00 GiB"},
+		{1<<40 - 1, "1024.00 GiB"},
+		{1 << 40, "1.00 TiB"},
+		{2048 << 30, "2.00 TiB"},
+		{1 << 50, "1024.00 TiB"},
+	}
+	for _, c := range cases {
+		if got := sizeStr(c.size); got != c.want {
+			t.Errorf("sizeStr(%d) = %q, expected %q", c.size, got, c.want)
+		}
+	}
+}
+
+func TestCacheControlMaxAge(t *testing.T) {
+	oldNext := nextRefresh.Load()
+	t.Cleanup(func() { nextRefresh.Store(oldNext) })
+
+	cases := []struct {
+		next time.Duration
+		want string
+	}{
+		{-time.Minute, "public, max-age=0"},
+		{30*time.Second + 500*time.Millisecond, "public, max-age=30"},
+		{24 * time.Hour, "public, max-age=60"},
+	}
+	for _, c := range cases {
+		nextRefresh.Store(time.Now().Add(c.next).UnixNano())
+		rec := httptest.NewRecorder()
+		setCacheControl(rec)
+		if cc := rec.Header().Get("Cache-Control"); cc != c.want {
+			t.Errorf("next refresh in %v: got Cache-Control %q, expected %q", c.next, cc, c.want)
+		}
+	}
+}
+
+func TestCacheControlPageAuth(t *testing.T) {
+	oldNext, oldAuth := nextRefresh.Load(), pageAuth
+	t.Cleanup(func() {
+		nextRefresh.Store(oldNext)
+		pageAuth = oldAuth
+	})
+	nextRefresh.Store(time.Now().Add(30 * time.Second).UnixNano())
+
+	for auth, want := range map[string]string{"": "public, ", "user:pass": "private, "} {
+		pageAuth = auth
+		rec := httptest.NewRecorder()
+		setCacheControl(rec)
+		if cc := rec.Header().Get("Cache-Control"); !strings.HasPrefix(cc, want) {
+			t.Errorf("with -page-auth %q: got Cache-Control %q", auth, cc)
+		}
+	}
+}
+
+func TestAPIBuildsFields(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":0}`))
+	}))
+	if err := refreshCache(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	apiBuilds(rec, httptest.NewRequest(http.MethodGet, "/api/builds", nil))
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if got, want := strings.Join(keys, ","), "Base,Branches,Errors,Projects,Updated"; got != want {
+		t.Errorf("got fields %s, expected %s", got, want)
+	}
+}
+
+func TestAuthBannerWhenListingFails(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	tc.token = "secret"
+
+	if err := refreshCache(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "TeamCity is rejecting our credentials") || !strings.Contains(body, "check -token") {
+		t.Errorf("auth banner not shown:\n%s", body)
+	}
+}
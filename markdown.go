@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// mdHandler serves the cached builds and their artifacts as Markdown, for
+// pasting into wikis and chat. The page filter parameters apply.
+func mdHandler(w http.ResponseWriter, req *http.Request) {
+	c := currentCache()
+	setCacheAge(w, c)
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	projs := c.projects
+	if f := pageFilterFrom(req); !f.empty() {
+		projs = f.apply(projs)
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	writeMarkdown(w, projs, func(u string) string { return absURL(req, u) })
+	fmt.Fprintf(w, "_Refreshed %s._\n", c.updated.In(displayLoc).Format("2006-01-02 15:04:05 MST"))
+}
+
+// writeMarkdown writes the projects with builds as Markdown, with a section
+// per project and a list of artifacts per build. Links to our own
+// handlers are made absolute with abs.
+func writeMarkdown(w io.Writer, projs []project, abs func(string) string) {
+	fmt.Fprint(w, "# Latest builds\n\n")
+	for _, p := range projs {
+		if len(p.Builds) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "## %s\n\n", mdEscape(p.Name))
+		for _, bt := range p.Builds {
+			b := bt.Build
+			if bt.Pending {
+				fmt.Fprintf(w, "### %s\n\nNo matching build yet.\n\n", mdEscape(bt.Name))
+				continue
+			}
+
+			fmt.Fprintf(w, "### %s [#%s](%s)\n\n", mdEscape(bt.Name), mdEscape(b.Number), b.WebURL)
+			info := []string{mdEscape(b.StatusText)}
+			if b.FinishDate != "" {
+				info = append(info, "completed "+b.DateStr())
+			}
+			if b.BranchName != "" {
+				info = append(info, "branch "+mdEscape(b.BranchName))
+			}
+			if commit := b.ShortCommit(); commit != "" {
+				if u := b.CommitURL(); u != "" {
+					info = append(info, fmt.Sprintf("commit [`%s`](%s)", commit, u))
+				} else {
+					info = append(info, fmt.Sprintf("commit `%s`", commit))
+				}
+			}
+			fmt.Fprintf(w, "%s\n\n", strings.Join(info, " · "))
+
+			for _, f := range b.Files {
+				if f.Content.HRef == "" {
+					continue
+				}
+				fmt.Fprintf(w, "- [%s](%s) (%s)\n", mdEscape(f.Name), abs(b.DownloadURL(f)), f.SizeStr())
+			}
+			if len(b.Files) > 0 {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+}
+
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// mdEscape escapes the characters that Markdown would interpret in text.
+func mdEscape(s string) string {
+	return mdEscaper.Replace(s)
+}
+
+// absURL returns the link as an absolute URL, resolving links to our own
+// handlers against the request.
+func absURL(req *http.Request, u string) string {
+	if strings.Contains(u, "://") {
+		return u
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + "/" + strings.TrimPrefix(u, "/")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tcbuilds",
+		Name:      "refreshes_total",
+		Help:      "Number of cache refreshes attempted.",
+	})
+	metricRefreshFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tcbuilds",
+		Name:      "refresh_failures_total",
+		Help:      "Number of cache refreshes that failed.",
+	})
+	metricRefreshDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "tcbuilds",
+		Name:      "refresh_duration_seconds",
+		Help:      "Time taken to refresh the cache.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+	metricHTTPErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tcbuilds",
+		Name:      "teamcity_http_errors_total",
+		Help:      "Number of failed HTTP requests to TeamCity.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		metricRefreshes,
+		metricRefreshFailures,
+		metricRefreshDuration,
+		metricHTTPErrors,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
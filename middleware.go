@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+)
+
+// Paths reachable without -page-auth credentials, for health probes.
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// withPageAuth requires HTTP basic auth matching the user:pass in creds,
+// if set, for all paths except the health probes, and the webhook when it
+// is protected by its own secret.
+func withPageAuth(creds string, next http.Handler) http.Handler {
+	if creds == "" {
+		return next
+	}
+	user, pass := creds, ""
+	if i := strings.IndexByte(creds, ':'); i >= 0 {
+		user, pass = creds[:i], creds[i+1:]
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		exempt := unauthenticatedPaths[req.URL.Path] || req.URL.Path == "/webhook" && webhookSecret != ""
+		if !exempt {
+			u, p, ok := req.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+			if !ok || !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="tcbuilds"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// withRecover turns a panic in next into a logged stack trace and a 500
+// response, instead of a dropped connection.
+func withRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate abort, let net/http handle it quietly
+				panic(p)
+			}
+			errorln(fmt.Sprintf("Panic serving %s: %v\n%s", req.URL.Path, p, debug.Stack()))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// defaultCSP returns the Content-Security-Policy allowing what the built
+// in page uses: our own assets, inline styles, and the configured CDN and
+// logo hosts.
+func defaultCSP() string {
+	styles := "'self' 'unsafe-inline'"
+	if useCDN {
+		styles += " https://maxcdn.bootstrapcdn.com"
+	}
+	images := "'self' data:"
+	if logo != "" && isRemoteLogo(logo) {
+		if u, err := url.Parse(logo); err == nil {
+			images += " " + u.Scheme + "://" + u.Host
+		}
+	}
+	return "default-src 'self'; style-src " + styles + "; img-src " + images + "; frame-ancestors 'none'"
+}
+
+// withSecurityHeaders sets security related headers, including the given
+// Content-Security-Policy if not empty, on all responses.
+func withSecurityHeaders(csp string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := w.Header()
+		if csp != "" {
+			h.Set("Content-Security-Policy", csp)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("X-Frame-Options", "DENY")
+		next.ServeHTTP(w, req)
+	})
+}
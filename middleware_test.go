@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWithRecover(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	h := withRecover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var m map[string]int
+		m["boom"]++ // assignment to a nil map
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, expected 500", rec.Code)
+	}
+	out := logged.String()
+	if !strings.Contains(out, "Panic serving /boom: assignment to entry in nil map") {
+		t.Errorf("panic not logged: %q", out)
+	}
+	if !strings.Contains(out, "middleware_test.go") {
+		t.Errorf("stack trace not logged: %q", out)
+	}
+}
+
+func TestWithRecoverAbort(t *testing.T) {
+	h := withRecover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("got %v, expected http.ErrAbortHandler to be passed on", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mirrorArtifacts downloads the artifacts of the latest builds that are not
+// yet in -mirror-dir. With -mirror-keep, older builds of each build type
+// are removed. It runs in the background after the cache is published, so
+// the projects are only read; linkMirrored points the files at their
+// copies on the next render.
+func mirrorArtifacts(ctx context.Context, projs []project) {
+	for _, p := range projs {
+		for _, bt := range p.Builds {
+			if !mirrorable(bt) {
+				continue
+			}
+			for _, f := range bt.Build.Files {
+				if f.Content.HRef == "" {
+					continue
+				}
+				if err := mirrorFile(ctx, bt.Server, f, mirrorPath(bt, f)); err != nil {
+					warnln(err)
+				}
+			}
+
+			if mirrorKeep > 0 {
+				if err := pruneMirror(path.Dir(mirrorBuildDir(bt)), mirrorKeep); err != nil {
+					warnln(err)
+				}
+			}
+		}
+	}
+}
+
+// linkMirrored points the files of the latest builds at their copies under
+// -mirror-dir, for those that have been mirrored.
+func linkMirrored(projs []project) {
+	for pi := range projs {
+		for bi := range projs[pi].Builds {
+			bt := &projs[pi].Builds[bi]
+			if !mirrorable(*bt) {
+				continue
+			}
+
+			// The files may be shared with the previous cache entry
+			files := make([]file, len(bt.Build.Files))
+			copy(files, bt.Build.Files)
+			for i, f := range files {
+				rel := mirrorPath(*bt, f)
+				if isMirrored(rel, f) {
+					files[i].Mirror = rel
+				}
+			}
+			bt.Build.Files = files
+		}
+	}
+}
+
+// mirrorable returns true if the build type's latest build is one whose
+// artifacts are mirrored.
+func mirrorable(bt buildType) bool {
+	return !bt.Pending && bt.Build.State == "finished" && len(bt.Build.Files) > 0
+}
+
+// mirrorBuildDir returns the directory of the build type's latest build
+// under -mirror-dir, as <project>/<build type>/<build number>.
+func mirrorBuildDir(bt buildType) string {
+	return path.Join(mirrorSegment(bt.ProjectName), mirrorSegment(bt.Name), mirrorSegment(bt.Build.Number))
+}
+
+// mirrorPath returns the path of the file under -mirror-dir.
+func mirrorPath(bt buildType, f file) string {
+	return path.Join(mirrorBuildDir(bt), path.Clean("/"+f.Name))
+}
+
+// isMirrored returns true if the file has been completely mirrored to rel.
+func isMirrored(rel string, f file) bool {
+	fi, err := os.Stat(filepath.Join(mirrorDir, filepath.FromSlash(rel)))
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == int64(f.Size)
+}
+
+// mirrorFile downloads the file to rel under -mirror-dir unless it is
+// already there.
+func mirrorFile(ctx context.Context, srv int, f file, rel string) error {
+	if isMirrored(rel, f) {
+		return nil
+	}
+	dst := filepath.Join(mirrorDir, filepath.FromSlash(rel))
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return errors.Wrap(err, "mirror")
+	}
+	resp, err := getContent(ctx, srv, f.Content.HRef)
+	if err != nil {
+		return errors.Wrap(err, "mirror "+f.Name)
+	}
+	defer resp.Body.Close()
+
+	// Write to a temporary file first so a failed download is retried
+	// on the next refresh.
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "mirror")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "mirror "+f.Name)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "mirror")
+	}
+	infoln("Mirrored", rel)
+	return errors.Wrap(os.Rename(tmp.Name(), dst), "mirror")
+}
+
+// pruneMirror removes all but the keep newest build directories of the
+// build type directory rel, ordering them by build number.
+func pruneMirror(rel string, keep int) error {
+	dir := filepath.Join(mirrorDir, filepath.FromSlash(rel))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "prune mirror")
+	}
+
+	var builds []string
+	for _, e := range entries {
+		if e.IsDir() {
+			builds = append(builds, e.Name())
+		}
+	}
+	sort.Slice(builds, func(a, b int) bool {
+		return naturalLess(builds[b], builds[a])
+	})
+	for len(builds) > keep {
+		old := builds[len(builds)-1]
+		builds = builds[:len(builds)-1]
+		infoln("Pruning mirrored", path.Join(rel, old))
+		if err := os.RemoveAll(filepath.Join(dir, old)); err != nil {
+			return errors.Wrap(err, "prune mirror")
+		}
+	}
+	return nil
+}
+
+// mirrorSegment makes a project, build type or build number name safe to
+// use as a single path segment.
+func mirrorSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', 0:
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// mirrorURL returns the link to a mirrored file.
+func mirrorURL(rel string) string {
+	return "mirror/" + (&url.URL{Path: rel}).EscapedPath()
+}
+
+// mirrorHandler serves -mirror-dir under /mirror/.
+func mirrorHandler() http.Handler {
+	return http.StripPrefix("/mirror/", http.FileServer(http.Dir(mirrorDir)))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMirrorLinksOnNextRender(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	oldDir := mirrorDir
+	mirrorDir = t.TempDir()
+	t.Cleanup(func() { mirrorDir = oldDir })
+
+	projs := []project{{Builds: []buildType{{
+		ProjectName: "Proj A",
+		Name:        "Build",
+		Build:       build{ID: 11, Number: "10", State: "finished", Files: []file{{Name: "app.zip", Size: 7}}},
+	}}}}
+	projs[0].Builds[0].Build.Files[0].Content.HRef = "/artifacts/content/app.zip"
+
+	mirrorArtifacts(context.Background(), projs)
+	if f := projs[0].Builds[0].Build.Files[0]; f.Mirror != "" {
+		t.Errorf("mirroring changed the published file: %+v", f)
+	}
+
+	linkMirrored(projs)
+	if got, want := projs[0].Builds[0].Build.Files[0].Mirror, "Proj A/Build/10/app.zip"; got != want {
+		t.Errorf("got mirror %q, expected %q", got, want)
+	}
+}
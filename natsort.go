@@ -0,0 +1,51 @@
+package main
+
+// naturalLess compares strings with runs of digits compared by numeric
+// value, so that "Build 2" sorts before "Build 10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			if na != nb {
+				// Equal width after stripping leading zeros means the
+				// strings compare as the numbers do.
+				ta, tb := trimZeros(na), trimZeros(nb)
+				if len(ta) != len(tb) {
+					return len(ta) < len(tb)
+				}
+				if ta != tb {
+					return ta < tb
+				}
+				return len(na) < len(nb)
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// splitDigits returns the leading run of digits in s, and the rest.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultPlatforms is the default -platforms mapping.
+const defaultPlatforms = "Linux=linux;macOS=darwin,macos,osx;Windows=windows,win32,win64;FreeBSD=freebsd"
+
+// defaultArchs is the default -archs mapping. amd64 comes before 386 so
+// that x86_64 isn't taken for x86.
+const defaultArchs = "amd64=amd64,x86_64,x64;arm64=arm64,aarch64;386=386,i386,i686,x86;arm=arm,armv6,armv7,armhf"
+
+// otherPlatform is the group of artifacts matching no platform.
+const otherPlatform = "Other"
+
+// A platformGroup is a named group of artifacts, recognized by tokens in
+// their file names.
+type platformGroup struct {
+	name   string
+	tokens map[string]bool
+}
+
+// platformGroups and archGroups are the groups from -platforms and -archs,
+// in order.
+var platformGroups, archGroups []platformGroup
+
+// parsePlatforms parses a mapping like "Linux=linux;macOS=darwin,osx" into
+// groups. Tokens are matched case insensitively. It is used for -archs too.
+func parsePlatforms(s string) ([]platformGroup, error) {
+	var groups []platformGroup
+	for _, def := range strings.Split(s, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		name, toks, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid platform %q, expected name=token,...", def)
+		}
+		g := platformGroup{name: name, tokens: make(map[string]bool)}
+		for _, t := range splitList(toks) {
+			g.tokens[strings.ToLower(t)] = true
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// platformOf returns the indexes of the first platform and architecture
+// group with a token in the file's base name, or -1 for none.
+func platformOf(name string) (int, int) {
+	base := strings.ToLower(path.Base(name))
+	return matchGroup(platformGroups, base), matchGroup(archGroups, base)
+}
+
+// matchGroup returns the index of the first group with a token in s, or -1.
+func matchGroup(groups []platformGroup, s string) int {
+	for i, g := range groups {
+		for t := range g.tokens {
+			if hasToken(s, t) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// hasToken returns true if t occurs in s delimited by anything but letters
+// and digits, so that tokens such as x86_64 can span delimiters.
+func hasToken(s, t string) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for i := 0; i < len(s); {
+		j := strings.Index(s[i:], t)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(t)
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if (start == 0 || !isWord(before)) && (end == len(s) || !isWord(after)) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+// A fileGroup is a list of artifacts for one platform.
+type fileGroup struct {
+	Name  string
+	Files []file
+}
+
+// groupFiles groups the files by platform and architecture, such as
+// "Linux arm64", in the order of -platforms and -archs. Files of a platform
+// with no architecture come first within it, and the other files last. If
+// no file matches a platform, a single group with no name is returned.
+func groupFiles(files []file) []fileGroup {
+	type key struct{ platform, arch int }
+	byKey := make(map[key][]file)
+	var keys []key
+	for _, f := range files {
+		k := key{}
+		k.platform, k.arch = platformOf(f.Name)
+		if k.platform < 0 {
+			// Architectures only divide platforms
+			k.arch = -1
+		}
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], f)
+	}
+	if len(byKey[key{-1, -1}]) == len(files) {
+		return []fileGroup{{Files: files}}
+	}
+
+	sort.Slice(keys, func(a, b int) bool {
+		pa, pb := keys[a].platform, keys[b].platform
+		if pa != pb {
+			return pb < 0 || pa >= 0 && pa < pb
+		}
+		return keys[a].arch < keys[b].arch
+	})
+	res := make([]fileGroup, 0, len(keys))
+	for _, k := range keys {
+		name := otherPlatform
+		if k.platform >= 0 {
+			name = platformGroups[k.platform].name
+			if k.arch >= 0 {
+				name += " " + archGroups[k.arch].name
+			}
+		}
+		res = append(res, fileGroup{Name: name, Files: byKey[k]})
+	}
+	return res
+}
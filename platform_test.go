@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupFilesByArch(t *testing.T) {
+	oldPlatforms, oldArchs := platformGroups, archGroups
+	t.Cleanup(func() { platformGroups, archGroups = oldPlatforms, oldArchs })
+	var err error
+	if platformGroups, err = parsePlatforms(defaultPlatforms); err != nil {
+		t.Fatal(err)
+	}
+	if archGroups, err = parsePlatforms(defaultArchs); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []file
+	for _, name := range []string{
+		"README.txt",
+		"app-windows-amd64.zip",
+		"app_linux_x86_64.tar.gz",
+		"app-linux-aarch64.tar.gz",
+		"app-linux.deb",
+		"app-arm64.zip",
+		"app-linux-armv7.tar.gz",
+		"app-linux-386.tar.gz",
+		"app-macos-arm64.dmg",
+	} {
+		files = append(files, file{Name: name})
+	}
+
+	var got []string
+	for _, g := range groupFiles(files) {
+		var names []string
+		for _, f := range g.Files {
+			names = append(names, f.Name)
+		}
+		got = append(got, g.Name+": "+strings.Join(names, ","))
+	}
+	want := []string{
+		"Linux: app-linux.deb",
+		"Linux amd64: app_linux_x86_64.tar.gz",
+		"Linux arm64: app-linux-aarch64.tar.gz",
+		"Linux 386: app-linux-386.tar.gz",
+		"Linux arm: app-linux-armv7.tar.gz",
+		"macOS arm64: app-macos-arm64.dmg",
+		"Windows amd64: app-windows-amd64.zip",
+		"Other: README.txt,app-arm64.zip",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got groups\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
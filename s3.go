@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// s3Uploaded remembers the keys known to be in the bucket, so that
+	// they aren't checked again on every refresh.
+	s3Uploaded = make(map[string]bool)
+	s3Mut      sync.Mutex
+
+	// s3Client gives up on a stalled bucket, while leaving time to upload
+	// large artifacts.
+	s3Client = &http.Client{
+		Timeout: 10 * time.Minute,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+		},
+	}
+)
+
+// syncS3 uploads the artifacts of the latest builds that are not yet in
+// -s3-bucket, keyed like the mirror as <project>/<build type>/<build
+// number>/<name>. Like mirrorArtifacts it runs in the background and only
+// reads the projects; linkUploaded points the files at the public copies
+// on the next render.
+func syncS3(ctx context.Context, projs []project) {
+	for _, p := range projs {
+		for _, bt := range p.Builds {
+			if !mirrorable(bt) {
+				continue
+			}
+			for _, f := range bt.Build.Files {
+				if f.Content.HRef == "" {
+					continue
+				}
+				if err := s3Upload(ctx, bt.Server, f, mirrorPath(bt, f)); err != nil {
+					warnln(err)
+				}
+			}
+		}
+	}
+}
+
+// linkUploaded points the files of the latest builds at their copies under
+// -s3-public-url, for those that have been uploaded.
+func linkUploaded(projs []project) {
+	s3Mut.Lock()
+	defer s3Mut.Unlock()
+	for pi := range projs {
+		for bi := range projs[pi].Builds {
+			bt := &projs[pi].Builds[bi]
+			if !mirrorable(*bt) {
+				continue
+			}
+
+			// The files may be shared with the previous cache entry
+			files := make([]file, len(bt.Build.Files))
+			copy(files, bt.Build.Files)
+			for i, f := range files {
+				key := mirrorPath(*bt, f)
+				if s3Uploaded[key] {
+					files[i].Public = strings.TrimSuffix(s3PublicURL, "/") + "/" + s3Escape(key, false)
+				}
+			}
+			bt.Build.Files = files
+		}
+	}
+}
+
+// s3Upload uploads the file to key unless it is already in the bucket. The
+// mirrored copy is used if there is one, otherwise the file is streamed
+// from TeamCity.
+func s3Upload(ctx context.Context, srv int, f file, key string) error {
+	s3Mut.Lock()
+	done := s3Uploaded[key]
+	s3Mut.Unlock()
+	if done {
+		return nil
+	}
+
+	resp, err := s3Do(ctx, http.MethodHead, key, nil, 0)
+	if err != nil {
+		return errors.Wrap(err, "S3 check "+key)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		s3Mut.Lock()
+		s3Uploaded[key] = true
+		s3Mut.Unlock()
+		return nil
+	case http.StatusNotFound:
+	default:
+		return errors.New("S3 check " + key + ": " + resp.Status)
+	}
+
+	var body io.Reader
+	var size int64
+	if mirrorDir != "" && isMirrored(key, f) {
+		fd, err := os.Open(filepath.Join(mirrorDir, filepath.FromSlash(key)))
+		if err != nil {
+			return errors.Wrap(err, "S3 upload")
+		}
+		defer fd.Close()
+		fi, err := fd.Stat()
+		if err != nil {
+			return errors.Wrap(err, "S3 upload")
+		}
+		body, size = fd, fi.Size()
+	} else {
+		src, err := getContent(ctx, srv, f.Content.HRef)
+		if err != nil {
+			return errors.Wrap(err, "S3 upload "+f.Name)
+		}
+		defer src.Body.Close()
+		if src.ContentLength < 0 {
+			return errors.New("S3 upload " + f.Name + ": unknown length")
+		}
+		body, size = src.Body, src.ContentLength
+	}
+
+	resp, err = s3Do(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return errors.Wrap(err, "S3 upload "+key)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(statusError(resp), "S3 upload "+key)
+	}
+
+	infoln("Uploaded", key, "to S3")
+	s3Mut.Lock()
+	s3Uploaded[key] = true
+	s3Mut.Unlock()
+	return nil
+}
+
+// s3Do performs a path style request for the key in -s3-bucket, signed
+// with AWS signature version 4. The payload is not signed, so that it can
+// be streamed.
+func s3Do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	escPath := "/" + s3Escape(s3Bucket, true) + "/" + s3Escape(key, false)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s3Endpoint, "/")+escPath, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("User-Agent", userAgent)
+	s3Sign(req, escPath, time.Now().UTC())
+	return s3Client.Do(req)
+}
+
+// s3Sign adds the signature version 4 headers to the request.
+func s3Sign(req *http.Request, escPath string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           amzDate,
+	}
+	var names []string
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + headers[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonReq := strings.Join([]string{req.Method, escPath, "", canonHeaders.String(), signed, "UNSIGNED-PAYLOAD"}, "\n")
+	scope := day + "/" + s3Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonReq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + s3SecretKey)
+	for _, part := range []string{day, s3Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3AccessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent encodes everything but unreserved characters, and
+// slashes unless escSlash is set, as signature version 4 requires.
+func s3Escape(s string, escSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !escSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSyncS3LinksOnNextRender(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("content"))
+	}))
+
+	var mut sync.Mutex
+	put := make(map[string]string)
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		switch req.Method {
+		case http.MethodHead:
+			if _, ok := put[req.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			bs, _ := io.ReadAll(req.Body)
+			put[req.URL.Path] = string(bs)
+		}
+	}))
+	t.Cleanup(bucket.Close)
+
+	oldEndpoint, oldBucket, oldPublic := s3Endpoint, s3Bucket, s3PublicURL
+	t.Cleanup(func() {
+		s3Endpoint, s3Bucket, s3PublicURL = oldEndpoint, oldBucket, oldPublic
+		s3Uploaded = make(map[string]bool)
+	})
+	s3Endpoint, s3Bucket, s3PublicURL = bucket.URL, "builds", "https://cdn.example.com/"
+	s3Uploaded = make(map[string]bool)
+
+	projs := []project{{Builds: []buildType{{
+		ProjectName: "Proj A",
+		Name:        "Build",
+		Build:       build{ID: 11, Number: "10", State: "finished", Files: []file{{Name: "app 1.zip", Size: 7}}},
+	}}}}
+	projs[0].Builds[0].Build.Files[0].Content.HRef = "/artifacts/content/app.zip"
+
+	syncS3(context.Background(), projs)
+	if got := put["/builds/Proj A/Build/10/app 1.zip"]; got != "content" {
+		t.Errorf("got upload %q, expected the artifact content", got)
+	}
+	if f := projs[0].Builds[0].Build.Files[0]; f.Public != "" {
+		t.Errorf("uploading changed the published file: %+v", f)
+	}
+
+	linkUploaded(projs)
+	if got, want := projs[0].Builds[0].Build.Files[0].Public, "https://cdn.example.com/Proj%20A/Build/10/app%201.zip"; got != want {
+		t.Errorf("got public link %q, expected %q", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// searchHandler returns the cached projects and build types whose names
+// contain the q parameter, ignoring case, as JSON. All build types of a
+// matching project are included.
+func searchHandler(w http.ResponseWriter, req *http.Request) {
+	c := currentCache()
+	setCacheAge(w, c)
+	if c == nil {
+		http.Error(w, "Building cache, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	q := strings.ToLower(query)
+	res := []project{}
+	for _, p := range c.projects {
+		if len(p.Builds) == 0 {
+			continue
+		}
+		if strings.Contains(strings.ToLower(p.Name), q) {
+			res = append(res, p)
+			continue
+		}
+		var bts []buildType
+		for _, bt := range p.Builds {
+			if strings.Contains(strings.ToLower(bt.Name), q) {
+				bts = append(bts, bt)
+			}
+		}
+		if len(bts) > 0 {
+			p.Builds = bts
+			p.TotalSize = 0
+			for _, bt := range bts {
+				p.TotalSize += bt.Build.TotalSize
+			}
+			res = append(res, p)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Query":    query,
+		"Projects": res,
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSlackTemplate is the default -slack-template, in Slack's mrkdwn.
+const defaultSlackTemplate = "*{{.Project}} / {{.BuildType}}* <{{.URL}}|#{{.Number}}>{{if .Branch}} on {{.Branch}}{{end}}: {{.StatusText}}"
+
+// maxSlackMessages is the number of new builds announced one by one after a
+// refresh. Beyond that they are summed up in one message.
+const maxSlackMessages = 10
+
+var (
+	slackTpl    *template.Template
+	slackClient = &http.Client{Timeout: 10 * time.Second}
+
+	// The latest build ID seen per build type and branch.
+	slackSeen = make(map[string]int)
+)
+
+// slackBuild is what -slack-template is executed with.
+type slackBuild struct {
+	Project    string
+	BuildType  string
+	Number     string
+	Branch     string
+	Status     string
+	StatusText string
+	URL        string
+}
+
+// parseSlackTemplate sets the template for Slack messages.
+func parseSlackTemplate(s string) error {
+	var err error
+	slackTpl, err = template.New("slack").Parse(s)
+	return err
+}
+
+// notifySlack posts a message for each build type whose latest build
+// changed since it was last seen. Build types seen for the first time are
+// only recorded, so a restart doesn't announce everything, and ones missing
+// from this refresh are remembered, so a build type that failed to load
+// doesn't have its old build announced again. Only called from the refresh
+// loop.
+func notifySlack(projs []project) {
+	var msgs []string
+	for _, p := range projs {
+		for _, bt := range p.Builds {
+			if bt.Pending {
+				continue
+			}
+			key := fmt.Sprintf("%d/%s@%s", bt.Server, bt.ID, bt.Build.BranchName)
+			prev, ok := slackSeen[key]
+			slackSeen[key] = bt.Build.ID
+			if !ok || prev == bt.Build.ID {
+				continue
+			}
+
+			buf := new(bytes.Buffer)
+			err := slackTpl.Execute(buf, slackBuild{
+				Project:    p.Name,
+				BuildType:  bt.Name,
+				Number:     bt.Build.Number,
+				Branch:     bt.Build.BranchName,
+				Status:     bt.Build.Status,
+				StatusText: bt.Build.StatusText,
+				URL:        bt.Build.WebURL,
+			})
+			if err != nil {
+				warnln("Slack template:", err)
+				continue
+			}
+			msgs = append(msgs, buf.String())
+		}
+	}
+
+	if len(msgs) > maxSlackMessages {
+		msgs = []string{fmt.Sprintf("%d new builds, see %s", len(msgs), tc.base)}
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	go func() {
+		for _, msg := range msgs {
+			if err := postSlack(appCtx, msg); err != nil {
+				warnln(err)
+			}
+		}
+	}()
+}
+
+// postSlack posts the message to -slack-webhook.
+func postSlack(ctx context.Context, msg string) error {
+	bs, err := json.Marshal(map[string]string{"text": msg})
+	if err != nil {
+		return errors.Wrap(err, "post to Slack")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackWebhook, bytes.NewReader(bs))
+	if err != nil {
+		return errors.Wrap(err, "post to Slack")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := slackClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post to Slack")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(statusError(resp), "post to Slack")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifySlack(t *testing.T) {
+	posted := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var msg map[string]string
+		json.NewDecoder(req.Body).Decode(&msg)
+		posted <- msg["text"]
+	}))
+	defer srv.Close()
+
+	oldWebhook, oldSeen := slackWebhook, slackSeen
+	t.Cleanup(func() { slackWebhook, slackSeen = oldWebhook, oldSeen })
+	slackWebhook = srv.URL
+	slackSeen = make(map[string]int)
+	if err := parseSlackTemplate("{{.BuildType}} #{{.Number}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(builds ...buildType) []project {
+		return []project{{Name: "Proj", Builds: builds}}
+	}
+	a := func(id int) buildType { return buildType{ID: "A", Name: "A", Build: build{ID: id, Number: "a"}} }
+	b := func(id int) buildType { return buildType{ID: "B", Name: "B", Build: build{ID: id, Number: "b"}} }
+
+	// The first refresh only records, the second loses B to a load error,
+	// and the third has a new build of A and the old one of B.
+	for _, projs := range [][]project{page(a(1), b(5)), page(a(1)), page(a(2), b(5))} {
+		notifySlack(projs)
+	}
+
+	select {
+	case msg := <-posted:
+		if msg != "A #a" {
+			t.Errorf("got message %q, expected A #a", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing posted")
+	}
+	select {
+	case msg := <-posted:
+		t.Errorf("unexpected message %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// Outcome of the last refresh attempt, successful or not.
+	lastRefresh    refreshOutcome
+	lastRefreshMut sync.Mutex
+)
+
+type refreshOutcome struct {
+	started  time.Time
+	duration time.Duration
+	err      error
+}
+
+func setLastRefresh(o refreshOutcome) {
+	lastRefreshMut.Lock()
+	lastRefresh = o
+	lastRefreshMut.Unlock()
+}
+
+// apiStatus reports the freshness of the cache and the outcome of the last
+// refresh, as JSON.
+func apiStatus(w http.ResponseWriter, _ *http.Request) {
+	lastRefreshMut.Lock()
+	o := lastRefresh
+	lastRefreshMut.Unlock()
+
+	res := struct {
+		Updated                 *time.Time
+		LastRefresh             *time.Time
+		LastRefreshDurationMs   int64
+		LastRefreshError        string
+		NextRefresh             *time.Time
+		Projects                int
+		BuildTypes              int
+		BuildTypesWithArtifacts int
+		Breakers                []breakerState
+	}{
+		LastRefreshDurationMs: o.duration.Milliseconds(),
+		LastRefreshError:      errString(o.err),
+	}
+	for _, c := range servers {
+		st := c.breaker.state()
+		st.Server = c.base
+		res.Breakers = append(res.Breakers, st)
+	}
+	if !o.started.IsZero() {
+		res.LastRefresh = &o.started
+	}
+	if next := nextRefresh.Load(); next != 0 {
+		t := time.Unix(0, next)
+		res.NextRefresh = &t
+	}
+	if c := currentCache(); c != nil {
+		res.Updated = &c.updated
+		for _, p := range c.projects {
+			if len(p.Builds) == 0 {
+				continue
+			}
+			res.Projects++
+			for _, bt := range p.Builds {
+				res.BuildTypes++
+				if len(bt.Build.Files) > 0 {
+					res.BuildTypesWithArtifacts++
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// defaultStatusColors is the mapping that -status-colors adds to.
+const defaultStatusColors = "running=warning,queued=warning,SUCCESS=success,FAILURE=danger,ERROR=danger,UNKNOWN=muted"
+
+// A status color is either a hex color or the name of a Bootstrap
+// contextual text class, such as "success" for text-success.
+var (
+	hexColorRe   = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	colorClassRe = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+)
+
+// statusColors is the mapping from -status-colors, keyed by upper case
+// state or status.
+var statusColors map[string]string
+
+// templateFuncs are the functions available to the page template.
+var templateFuncs = template.FuncMap{
+	"StatusColor": statusColor,
+}
+
+// parseStatusColors parses a mapping like "SUCCESS=success,ERROR=#8b0000"
+// from build states and statuses to colors, on top of defaultStatusColors.
+// Keys are matched case insensitively.
+func parseStatusColors(s string) (map[string]string, error) {
+	colors := make(map[string]string)
+	for _, def := range append(splitList(defaultStatusColors), splitList(s)...) {
+		key, color, ok := strings.Cut(def, "=")
+		key, color = strings.TrimSpace(key), strings.TrimSpace(color)
+		if !ok || key == "" || !hexColorRe.MatchString(color) && !colorClassRe.MatchString(color) {
+			return nil, fmt.Errorf("invalid status color %q, expected status=class or status=#rrggbb", def)
+		}
+		colors[strings.ToUpper(key)] = color
+	}
+	return colors, nil
+}
+
+// statusColor returns the class or style attribute coloring a build with
+// the given state and status. Unfinished builds are colored by state,
+// finished ones by status. Unmapped builds are colored as running or
+// failed.
+func statusColor(state, status string) template.HTMLAttr {
+	key, fallback := status, "danger"
+	if state != "finished" {
+		key, fallback = state, "warning"
+	}
+	color, ok := statusColors[strings.ToUpper(key)]
+	if !ok {
+		color = fallback
+	}
+
+	// Both forms were validated by parseStatusColors
+	if strings.HasPrefix(color, "#") {
+		return template.HTMLAttr(`style="color: ` + color + `"`)
+	}
+	return template.HTMLAttr(`class="text-` + color + `"`)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestStatusColors(t *testing.T) {
+	old := statusColors
+	t.Cleanup(func() { statusColors = old })
+
+	var err error
+	statusColors, err = parseStatusColors("ERROR=#8b0000,queued=muted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		state, status string
+		want          template.HTMLAttr
+	}{
+		{"finished", "SUCCESS", `class="text-success"`},
+		{"finished", "FAILURE", `class="text-danger"`},
+		{"finished", "ERROR", `style="color: #8b0000"`},
+		{"finished", "whatever", `class="text-danger"`},
+		{"running", "SUCCESS", `class="text-warning"`},
+		{"queued", "", `class="text-muted"`},
+	}
+	for _, c := range cases {
+		if got := statusColor(c.state, c.status); got != c.want {
+			t.Errorf("statusColor(%q, %q) = %s, expected %s", c.state, c.status, got, c.want)
+		}
+	}
+}
+
+func TestStatusColorsInvalid(t *testing.T) {
+	for _, s := range []string{"SUCCESS", "=success", "SUCCESS=#12", "SUCCESS=red; x"} {
+		if _, err := parseStatusColors(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
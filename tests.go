@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Test counts keyed by server and build ID. Only finished builds are
+	// cached, as the counts of running builds still change.
+	testsCache = make(map[[2]int]*testCounts)
+	testsMut   sync.Mutex
+)
+
+// A testCounts summarizes the test results of a build.
+type testCounts struct {
+	Passed  int
+	Failed  int
+	Ignored int
+	URL     string // the tests tab of the build
+}
+
+// buildTests returns the test counts of the build, or nil if it has no
+// test data.
+func buildTests(ctx context.Context, srv int, b build) (*testCounts, error) {
+	key := [2]int{srv, b.ID}
+	testsMut.Lock()
+	counts, ok := testsCache[key]
+	testsMut.Unlock()
+	if ok {
+		return counts, nil
+	}
+
+	stats, err := getStatistics(ctx, srv, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	counts = parseTestCounts(stats)
+	if counts != nil {
+		counts.URL = testsURL(b.WebURL)
+	}
+
+	if b.State == "finished" {
+		testsMut.Lock()
+		testsCache[key] = counts
+		testsMut.Unlock()
+	}
+	return counts, nil
+}
+
+// parseTestCounts returns the test counts from the build statistics, or
+// nil if there are none.
+func parseTestCounts(stats map[string]string) *testCounts {
+	var counts testCounts
+	found := false
+	for name, dst := range map[string]*int{
+		"PassedTestCount":  &counts.Passed,
+		"FailedTestCount":  &counts.Failed,
+		"IgnoredTestCount": &counts.Ignored,
+	} {
+		if n, err := strconv.Atoi(stats[name]); err == nil {
+			*dst = n
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &counts
+}
+
+// testsURL returns the link to the tests tab of the build page.
+func testsURL(webURL string) string {
+	u, err := url.Parse(webURL)
+	if err != nil || webURL == "" {
+		return webURL
+	}
+	q := u.Query()
+	if strings.HasSuffix(u.Path, "/viewLog.html") {
+		q.Set("tab", "testsInfo")
+	} else {
+		q.Set("buildTab", "tests")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A certReloader serves a TLS certificate from disk, reloading it when the
+// certificate or key file changes so that rotated certificates are picked
+// up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mut     sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.lastModified().After(r.modTime) {
+		if err := r.reload(); err != nil {
+			// Keep using the old certificate, the new one may be half
+			// written.
+			errorln("Reloading certificate:", err)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime := r.lastModified()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "load certificate")
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certReloader) lastModified() time.Time {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
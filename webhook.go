@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// webhookSecretHeader carries the -webhook-secret in webhook requests.
+const webhookSecretHeader = "X-Tcbuilds-Secret"
+
+// Webhook payloads are small; anything larger is not from TeamCity.
+const maxWebhookBody = 1 << 20
+
+// webhookBuild is the part of a TeamCity build notification we care about.
+// The build ID is kept raw since it's a number in some payload templates
+// and a string in others.
+type webhookBuild struct {
+	BuildTypeID string          `json:"buildTypeId"`
+	BuildID     json.RawMessage `json:"buildId"`
+	Status      string          `json:"buildStatus"`
+}
+
+// webhookPayload accepts the build either at the top level or, as sent by
+// the tcWebHooks plugin, nested under "build".
+type webhookPayload struct {
+	webhookBuild
+	Build *webhookBuild `json:"build"`
+}
+
+// webhookHandler queues a refresh of the build type a TeamCity build
+// notification is about.
+func webhookHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if webhookSecret != "" {
+		got := req.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(webhookSecret)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	var payload webhookPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxWebhookBody)).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b := payload.webhookBuild
+	if payload.Build != nil {
+		b = *payload.Build
+	}
+	if b.BuildTypeID == "" {
+		http.Error(w, "Missing buildTypeId", http.StatusBadRequest)
+		return
+	}
+
+	logEvent(slog.LevelInfo, "webhook", "Webhook for "+b.BuildTypeID, "build_type", b.BuildTypeID, "build_id", string(b.BuildID), "status", b.Status)
+	requestRefresh(b.BuildTypeID)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// zipHandler streams the artifacts shown for the build given in the path,
+// which must be on the page, as a zip archive.
+func zipHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/zip/"))
+	srv, ok := serverParam(req)
+	if err != nil || !ok || !listedBuild(srv, id) {
+		http.NotFound(w, req)
+		return
+	}
+
+	files, err := getFiles(req.Context(), srv, id)
+	if err != nil {
+		errorln(err)
+		http.Error(w, "Could not list artifacts", http.StatusBadGateway)
+		return
+	}
+	files = filterFiles(files)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="build-%d.zip"`, id))
+
+	// Once we've started writing there is no way to signal an error to
+	// the client other than aborting, leaving a truncated archive.
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		if f.Content.HRef == "" {
+			// Directory
+			continue
+		}
+		if err := zipFile(req.Context(), zw, srv, f); err != nil {
+			errorln("Zipping build", id, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		errorln("Zipping build", id, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func zipFile(ctx context.Context, zw *zip.Writer, srv int, f file) error {
+	resp, err := getContent(ctx, srv, f.Content.HRef)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fw, err := zw.Create(f.Name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, resp.Body)
+	return err
+}
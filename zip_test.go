@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestZipFiltersArtifacts(t *testing.T) {
+	fx := fixtures{
+		"/guestAuth/app/rest/builds/id:11/artifacts/children": `{"count": 2, "file": [
+			{"name": ".teamcity", "children": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/children/.teamcity"}},
+			{"name": "app.zip", "size": 7, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/app.zip"}}
+		]}`,
+		"/guestAuth/app/rest/builds/id:11/artifacts/children/.teamcity": `{"count": 1, "file": [
+			{"name": "settings.xml", "size": 9, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/.teamcity/settings.xml"}}
+		]}`,
+	}
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/artifacts/content/") {
+			w.Write([]byte("content"))
+			return
+		}
+		fx.ServeHTTP(w, req)
+	}))
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{{Build: build{ID: 11}}}}}})
+	old := artifactExcludeRe
+	t.Cleanup(func() { artifactExcludeRe = old })
+	artifactExcludeRe = regexp.MustCompile(artifactExclude)
+
+	rec := httptest.NewRecorder()
+	zipHandler(rec, httptest.NewRequest(http.MethodGet, "/zip/11", nil))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "app.zip" {
+		t.Errorf("got %s in the archive, expected only app.zip", got)
+	}
+}
+
+func TestZipAbortsOnError(t *testing.T) {
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/artifacts/children"):
+			fixtures{req.URL.Path: `{"count": 2, "file": [
+				{"name": "a.zip", "size": 7, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/a.zip"}},
+				{"name": "b.zip", "size": 7, "content": {"href": "/guestAuth/app/rest/builds/id:11/artifacts/content/b.zip"}}
+			]}`}.ServeHTTP(w, req)
+		case strings.HasSuffix(req.URL.Path, "/a.zip"):
+			w.Write([]byte("content"))
+		default:
+			http.Error(w, "gone", http.StatusInternalServerError)
+		}
+	}))
+	cache.Store(&cacheEntry{projects: []project{{Builds: []buildType{{Build: build{ID: 11}}}}}})
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("got %v, expected the handler to abort", p)
+		}
+	}()
+	zipHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/zip/11", nil))
+}